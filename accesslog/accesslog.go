@@ -0,0 +1,216 @@
+package accesslog
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/dabbertorres/web-srv-base/httpx"
+	"github.com/dabbertorres/web-srv-base/logme"
+	"github.com/dabbertorres/web-srv-base/realip"
+	"github.com/dabbertorres/web-srv-base/reqid"
+)
+
+const (
+	FormatCombined = "combined"
+	FormatJSON     = "json"
+	FormatOff      = "off"
+
+	fileName = "access"
+
+	// as Apache's %t
+	combinedTimeLayout = "02/Jan/2006:15:04:05 -0700"
+	jsonTimeLayout     = "2006-01-02T15:04:05.000Z07:00"
+)
+
+var ErrUnknownFormat = errors.New("unknown access log format")
+
+var (
+	mutex  sync.RWMutex
+	format string
+	file   *logme.File
+)
+
+// Open starts writing access.log (rotated along with the other logs) in format: "combined" (Apache's Combined Log
+// Format, followed by the request's duration in microseconds, and ID), "json" (an object per line), or "off".
+// Streams have no duration, as it's however long the client watched for, not how quickly it was served.
+// The access log doesn't need the database, so records everything, even when the database is down.
+func Open(f string) error {
+	switch f {
+	case FormatCombined, FormatJSON:
+	case FormatOff:
+		return nil
+	default:
+		return fmt.Errorf("%v: %q", ErrUnknownFormat, f)
+	}
+
+	opened, err := logme.OpenFile(fileName)
+	if err != nil {
+		return err
+	}
+
+	mutex.Lock()
+	format = f
+	file = opened
+	mutex.Unlock()
+	return nil
+}
+
+// Middleware writes a line to the access log for each request, once it's been served.
+// It should wrap the whole router, so requests that don't match a route are logged too.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.RLock()
+		f, out := format, file
+		mutex.RUnlock()
+
+		if out == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		start := time.Now()
+		rw := httpx.Wrap(w)
+		next.ServeHTTP(rw, r)
+
+		e := entry{
+			time:     start,
+			ip:       realip.String(r),
+			method:   r.Method,
+			uri:      r.RequestURI,
+			proto:    r.Proto,
+			status:   rw.Status(),
+			bytes:    rw.Bytes(),
+			referrer: r.Referer(),
+			agent:    r.UserAgent(),
+			duration: time.Since(start),
+			stream:   rw.Streaming(),
+			// set by reqid.Middleware, deeper in
+			request: w.Header().Get(reqid.Header),
+		}
+
+		var buf bytes.Buffer
+		if f == FormatJSON {
+			e.writeJSON(&buf)
+		} else {
+			e.writeCombined(&buf)
+		}
+		out.Write(buf.Bytes())
+	})
+}
+
+type entry struct {
+	time     time.Time
+	ip       string
+	method   string
+	uri      string
+	proto    string
+	status   int
+	bytes    int64
+	referrer string
+	agent    string
+	duration time.Duration
+	stream   bool
+	request  string
+}
+
+// ie: 127.0.0.1 - - [10/Oct/2000:13:55:36 -0700] "GET /index HTTP/1.1" 200 2326 "http://example.com/" "Mozilla/5.0" 1532 4f1c2a9e0b7d3c5a6e8f9012
+func (e *entry) writeCombined(buf *bytes.Buffer) {
+	buf.WriteString(e.ip)
+	buf.WriteString(" - - [")
+	buf.WriteString(e.time.Format(combinedTimeLayout))
+	buf.WriteString(`] "`)
+	writeEscaped(buf, e.method+" "+e.uri+" "+e.proto)
+	buf.WriteString(`" `)
+	buf.WriteString(strconv.Itoa(e.status))
+	buf.WriteByte(' ')
+	if e.bytes == 0 {
+		buf.WriteByte('-')
+	} else {
+		buf.WriteString(strconv.FormatInt(e.bytes, 10))
+	}
+	buf.WriteString(` "`)
+	writeEscaped(buf, orDash(e.referrer))
+	buf.WriteString(`" "`)
+	writeEscaped(buf, orDash(e.agent))
+	buf.WriteString(`" `)
+	if e.stream {
+		buf.WriteByte('-')
+	} else {
+		buf.WriteString(strconv.FormatInt(int64(e.duration/time.Microsecond), 10))
+	}
+	buf.WriteByte(' ')
+	writeEscaped(buf, orDash(e.request))
+	buf.WriteByte('\n')
+}
+
+// orDash returns s, or "-" (Apache's "no value") if it's empty
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// writeEscaped writes s as Apache does: quotes and backslashes escaped, and control and non-ASCII bytes as \xhh,
+// so a client can't forge lines.
+func writeEscaped(buf *bytes.Buffer, s string) {
+	const hex = "0123456789abcdef"
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '"' || c == '\\':
+			buf.WriteByte('\\')
+			buf.WriteByte(c)
+		case c < ' ' || c > '~':
+			buf.WriteString(`\x`)
+			buf.WriteByte(hex[c>>4])
+			buf.WriteByte(hex[c&0xf])
+		default:
+			buf.WriteByte(c)
+		}
+	}
+}
+
+// ie: {"time":"2000-10-10T13:55:36.000-07:00","ip":"127.0.0.1","method":"GET","uri":"/index","status":200,...}
+func (e *entry) writeJSON(buf *bytes.Buffer) {
+	var duration *int64
+	if !e.stream {
+		us := int64(e.duration / time.Microsecond)
+		duration = &us
+	}
+
+	json.NewEncoder(buf).Encode(&struct {
+		Time     string `json:"time"`
+		IP       string `json:"ip"`
+		Method   string `json:"method"`
+		URI      string `json:"uri"`
+		Proto    string `json:"proto"`
+		Status   int    `json:"status"`
+		Bytes    int64  `json:"bytes"`
+		Referrer string `json:"referrer,omitempty"`
+		Agent    string `json:"userAgent,omitempty"`
+		Duration *int64 `json:"durationUs,omitempty"` // microseconds
+		Stream   bool   `json:"stream,omitempty"`
+		Request  string `json:"request,omitempty"`
+	}{
+		Time:     e.time.Format(jsonTimeLayout),
+		IP:       e.ip,
+		Method:   e.method,
+		URI:      e.uri,
+		Proto:    e.proto,
+		Status:   e.status,
+		Bytes:    e.bytes,
+		Referrer: e.referrer,
+		Agent:    e.agent,
+		Duration: duration,
+		Stream:   e.stream,
+		Request:  e.request,
+	})
+}
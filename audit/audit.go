@@ -0,0 +1,76 @@
+package audit
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/dabbertorres/web-srv-base/db"
+	"github.com/dabbertorres/web-srv-base/dialogue"
+	"github.com/dabbertorres/web-srv-base/logme"
+	"github.com/dabbertorres/web-srv-base/realip"
+)
+
+// actions
+const (
+	Login          = "login"
+	LoginFailed    = "login.failed"
+	AccessDenied   = "access.denied"
+	PasswordChange = "password.change"
+	UserCreate     = "user.create"
+	UserEnable     = "user.enable"
+	UserDisable    = "user.disable"
+	IdentityLink   = "identity.link"
+	RoleSet        = "role.set"
+	RoleDelete     = "role.delete"
+	RoleAssign     = "role.assign"
+	RoleUnassign   = "role.unassign"
+	TokenCreate    = "token.create"
+	TokenRevoke    = "token.revoke"
+	SessionRevoke  = "session.revoke"
+
+	ImpersonateStart = "impersonate.start"
+	ImpersonateStop  = "impersonate.stop"
+)
+
+var logger = logme.For("audit")
+
+// Meta is extra, action specific, detail about an event.
+type Meta map[string]interface{}
+
+// Record adds an event to the audit log, performed by the logged in user of r.
+// Failures are logged rather than returned, as failing to audit shouldn't fail the request being audited.
+func Record(r *http.Request, action, target string, meta Meta) {
+	_, actor := dialogue.IsLoggedIn(r)
+
+	// attribute everything to whoever is really behind the session
+	if impersonating, impersonator := dialogue.Impersonator(r); impersonating {
+		if meta == nil {
+			meta = Meta{}
+		}
+		meta["as"] = actor
+		actor = impersonator
+	}
+
+	event := db.AuditEvent{
+		Time:   time.Now().UTC(),
+		Actor:  actor,
+		Target: target,
+		Action: action,
+		IP:     realip.String(r),
+	}
+
+	if len(meta) != 0 {
+		buf, err := json.Marshal(meta)
+		if err != nil {
+			logger.Ctx(r.Context()).Warn("json encoding metadata", "action", action, "err", err)
+		} else {
+			event.Metadata = string(buf)
+		}
+	}
+
+	err := db.AuditAdd(r.Context(), &event)
+	if err != nil {
+		logger.Ctx(r.Context()).Error("writing event", "action", action, "actor", actor, "target", target, "err", err)
+	}
+}
@@ -6,20 +6,154 @@ create table if not exists users
     name     varchar(32) primary key,
     email    varchar(64) unique not null,
     password binary(60) not null,
-    admin    bool       not null,
     enabled  bool       not null
 );
 
+create table if not exists roles
+(
+    name        varchar(32) primary key,
+    description varchar(255) not null
+);
+
+create table if not exists role_permissions
+(
+    role       varchar(32) not null,
+    permission varchar(32) not null,
+    primary key (role, permission),
+    foreign key (role) references roles (name)
+        on delete cascade
+        on update cascade
+);
+
+create table if not exists user_roles
+(
+    user varchar(32) not null,
+    role varchar(32) not null,
+    primary key (user, role),
+    foreign key (user) references users (name)
+        on delete cascade
+        on update cascade,
+    foreign key (role) references roles (name)
+        on delete cascade
+        on update cascade
+);
+
+insert ignore into roles (name, description)
+values ('admin', 'Full access to the admin site');
+
+insert ignore into role_permissions (role, permission)
+values ('admin', 'visits.view'),
+       ('admin', 'users.manage'),
+       ('admin', 'sessions.revoke'),
+       ('admin', 'users.impersonate'),
+       ('admin', 'audit.view');
+
 create table if not exists visits
 (
-    user      varchar(32)   null,
-    time      datetime      not null,
-    ip        varbinary(16) not null,
-    userAgent varchar(64),
-    path      varchar(32)   not null,
-    action    enum ('GET', 'HEAD', 'POST', 'PUT', 'DELETE', 'CONNECT', 'OPTIONS', 'TRACE', 'PATCH'),
-    params    json,
+    id           bigint unsigned auto_increment primary key,
+    user         varchar(32)   null,
+    time         datetime      not null,
+    ip           varbinary(16) not null,
+    userAgent    varchar(255),
+    path         varchar(255)  not null,
+    action       enum ('GET', 'HEAD', 'POST', 'PUT', 'DELETE', 'CONNECT', 'OPTIONS', 'TRACE', 'PATCH'),
+    params       json,
+    impersonator varchar(32)   null,
+    status       smallint unsigned null,
+    bytes        bigint unsigned null,
+    duration     int unsigned  null,
+    referrer     varchar(255)  null,
+    class        varchar(16)   not null default 'human',
+    country      char(2)       null,
+    region       varchar(3)    null,
+    utmSource    varchar(255)  null,
+    utmMedium    varchar(255)  null,
+    utmCampaign  varchar(255)  null,
+    utmTerm      varchar(255)  null,
+    utmContent   varchar(255)  null,
+    index visits_time (time),
+    index visits_time_status (time, status),
+    index visits_path_time (path, time),
+    index visits_time_class (time, class),
+    index visits_time_country (time, country),
     foreign key (user) references users (name)
         on delete set null
         on update cascade
 );
+
+create table if not exists visit_rollups
+(
+    day      date         not null,
+    path     varchar(255) not null,
+    views    int unsigned not null,
+    visitors int unsigned not null,
+    bots     int unsigned not null default 0,
+    primary key (day, path)
+);
+
+create table if not exists identities
+(
+    issuer  varchar(255) not null,
+    subject varchar(255) not null,
+    user    varchar(32)  not null,
+    created datetime     not null,
+    primary key (issuer, subject),
+    foreign key (user) references users (name)
+        on delete cascade
+        on update cascade
+);
+
+create table if not exists tokens
+(
+    id       bigint unsigned auto_increment primary key,
+    user     varchar(32)  not null,
+    name     varchar(64)  not null,
+    hash     binary(32)   not null unique,
+    scopes   varchar(255) not null,
+    created  datetime     not null,
+    lastUsed datetime     not null,
+    foreign key (user) references users (name)
+        on delete cascade
+        on update cascade
+);
+
+create table if not exists audit_events
+(
+    id       bigint unsigned auto_increment primary key,
+    time     datetime     not null,
+    actor    varchar(32)  null,
+    target   varchar(255) null,
+    action   varchar(32)  not null,
+    ip       varchar(64)  not null,
+    metadata json,
+    index (time),
+    index (actor, time),
+    index (action, time)
+);
+
+create trigger if not exists audit_events_no_update
+    before update on audit_events
+    for each row signal sqlstate '45000' set message_text = 'audit_events is append-only';
+
+create trigger if not exists audit_events_no_delete
+    before delete on audit_events
+    for each row signal sqlstate '45000' set message_text = 'audit_events is append-only';
+
+-- this schema already includes these, so the server shouldn't apply them
+create table if not exists migrations
+(
+    name    varchar(255) primary key,
+    applied datetime     not null
+);
+
+insert ignore into migrations (name, applied)
+values ('001-roles.sql', now()),
+       ('002-impersonation.sql', now()),
+       ('003-audit.sql', now()),
+       ('004-visit-response.sql', now()),
+       ('005-visit-analytics.sql', now()),
+       ('006-visit-retention.sql', now()),
+       ('007-visit-class.sql', now()),
+       ('008-visit-geoip.sql', now()),
+       ('009-visit-campaigns.sql', now()),
+       ('010-visit-ids.sql', now());
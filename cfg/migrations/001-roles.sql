@@ -0,0 +1,46 @@
+-- replaces users.admin with roles and permissions
+
+create table if not exists roles
+(
+    name        varchar(32) primary key,
+    description varchar(255) not null
+);
+
+create table if not exists role_permissions
+(
+    role       varchar(32) not null,
+    permission varchar(32) not null,
+    primary key (role, permission),
+    foreign key (role) references roles (name)
+        on delete cascade
+        on update cascade
+);
+
+create table if not exists user_roles
+(
+    user varchar(32) not null,
+    role varchar(32) not null,
+    primary key (user, role),
+    foreign key (user) references users (name)
+        on delete cascade
+        on update cascade,
+    foreign key (role) references roles (name)
+        on delete cascade
+        on update cascade
+);
+
+insert ignore into roles (name, description)
+values ('admin', 'Full access to the admin site');
+
+insert ignore into role_permissions (role, permission)
+values ('admin', 'visits.view'),
+       ('admin', 'users.manage'),
+       ('admin', 'sessions.revoke');
+
+insert ignore into user_roles (user, role)
+select name, 'admin'
+from users
+where admin = true;
+
+alter table users
+    drop column if exists admin;
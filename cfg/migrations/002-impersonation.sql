@@ -0,0 +1,7 @@
+-- lets admins act as other users, and records when they do
+
+insert ignore into role_permissions (role, permission)
+values ('admin', 'users.impersonate');
+
+alter table visits
+    add column if not exists impersonator varchar(32) null;
@@ -0,0 +1,26 @@
+-- structured, append-only log of security relevant events
+
+create table if not exists audit_events
+(
+    id       bigint unsigned auto_increment primary key,
+    time     datetime     not null,
+    actor    varchar(32)  null,
+    target   varchar(255) null,
+    action   varchar(32)  not null,
+    ip       varchar(64)  not null,
+    metadata json,
+    index (time),
+    index (actor, time),
+    index (action, time)
+);
+
+create trigger if not exists audit_events_no_update
+    before update on audit_events
+    for each row signal sqlstate '45000' set message_text = 'audit_events is append-only';
+
+create trigger if not exists audit_events_no_delete
+    before delete on audit_events
+    for each row signal sqlstate '45000' set message_text = 'audit_events is append-only';
+
+insert ignore into role_permissions (role, permission)
+values ('admin', 'audit.view');
@@ -0,0 +1,6 @@
+-- record the response to each visit
+
+alter table visits
+    add column if not exists status smallint unsigned null,
+    add column if not exists bytes bigint unsigned null,
+    add column if not exists duration int unsigned null;
@@ -0,0 +1,9 @@
+-- room for real world paths and user agents, and indexes for aggregating visits
+
+alter table visits
+    modify column userAgent varchar(255),
+    modify column path varchar(255) not null;
+
+create index if not exists visits_time on visits (time);
+create index if not exists visits_time_status on visits (time, status);
+create index if not exists visits_path_time on visits (path, time);
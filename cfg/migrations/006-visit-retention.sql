@@ -0,0 +1,10 @@
+-- daily summaries of visits removed by the retention policy
+
+create table if not exists visit_rollups
+(
+    day      date         not null,
+    path     varchar(255) not null,
+    views    int unsigned not null,
+    visitors int unsigned not null,
+    primary key (day, path)
+);
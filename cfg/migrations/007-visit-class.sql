@@ -0,0 +1,20 @@
+-- whether each visit was by a person, or by a crawler, script, or automated browser
+
+alter table visits
+    add column if not exists class varchar(16) not null default 'human';
+
+alter table visit_rollups
+    add column if not exists bots int unsigned not null default 0;
+
+create index if not exists visits_time_class on visits (time, class);
+
+-- a best guess for existing visits, from their user agents
+update visits
+set class = 'headless'
+where userAgent regexp '(?i)headlesschrome|phantomjs|puppeteer|playwright|selenium|slimerjs';
+
+update visits
+set class = 'bot'
+where class = 'human'
+  and (userAgent is null or userAgent = '' or path = '/robots.txt'
+    or userAgent regexp '(?i)bot|crawl|spider|slurp|curl/|wget/|python-|go-http-client|java/|libwww|httpclient|okhttp|scrapy|facebookexternalhit|feedfetcher|mediapartners-google');
@@ -0,0 +1,7 @@
+-- where visitors are, from the GeoIP database
+
+alter table visits
+    add column if not exists country char(2) null,
+    add column if not exists region varchar(3) null;
+
+create index if not exists visits_time_country on visits (time, country);
@@ -0,0 +1,9 @@
+-- referrers, and utm_* campaign parameters
+
+alter table visits
+    add column if not exists referrer varchar(255) null,
+    add column if not exists utmSource varchar(255) null,
+    add column if not exists utmMedium varchar(255) null,
+    add column if not exists utmCampaign varchar(255) null,
+    add column if not exists utmTerm varchar(255) null,
+    add column if not exists utmContent varchar(255) null;
@@ -0,0 +1,4 @@
+-- a stable order for paging through visits
+
+alter table visits
+    add column if not exists id bigint unsigned not null auto_increment primary key first;
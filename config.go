@@ -7,6 +7,30 @@ const (
 	dbConn    = "/db"
 	certRenew = 24 * 30 // LetsEncrypt recommends renewal at 30 days before expiration for their 90 day certs
 	certEmail = ""
+	oidcScope = "openid email profile"
+
+	visitQueue = 4096
+	visitBatch = 100
+	visitFlush = 1000 // milliseconds
+
+	visitRules  = "/visit-rules.conf"
+	visitIPMode = "truncate"
+	visitDNT    = true
+
+	geoIPReload = 300 // seconds
+
+	logLevel    = "info"
+	logFormat   = "text"
+	logMaxSize  = 100 // megabytes
+	logRotate   = 24  // hours
+	logMaxAge   = 30  // days
+	logCompress = true
+	accessLog   = "combined"
+	traceName   = "web-srv-base"
+
+	shutdownDrain = 5 // seconds
+
+	proxyHeader = "X-Forwarded-For"
 )
 
 type Config struct {
@@ -16,6 +40,46 @@ type Config struct {
 	SessionTTL int    `how-long:"session-ttl" how-env:"WEB_SRV_SESSION_TTL" how-help:"specify the time-to-live for a session"`
 	CertRenew  int    `how-long:"cert-renew" how-env:"WEB_SRV_CERT_RENEW" how-help:"specify the number of hours before certs are set to expire to renew certs"`
 	CertEmail  string `how:"cert-email" how-env:"WEB_SRV_CERT_EMAIL" how-help:"set a contact email address for Let's Encrypt to send notifications to'"`
+
+	OIDCIssuer   string `how-long:"oidc-issuer" how-env:"WEB_SRV_OIDC_ISSUER" how-help:"set an OpenID Connect issuer URL to enable signing in with it"`
+	OIDCClientID string `how-long:"oidc-client-id" how-env:"WEB_SRV_OIDC_CLIENT_ID" how-help:"specify the client ID registered with the OpenID Connect provider"`
+	OIDCRedirect string `how-long:"oidc-redirect" how-env:"WEB_SRV_OIDC_REDIRECT" how-help:"specify the redirect URL registered with the OpenID Connect provider (default: https://<hostname>/login/oidc/callback)"`
+	OIDCScope    string `how-long:"oidc-scope" how-env:"WEB_SRV_OIDC_SCOPE" how-help:"specify the space separated scopes to request from the OpenID Connect provider"`
+
+	VisitQueue int    `how-long:"visit-queue" how-env:"WEB_SRV_VISIT_QUEUE" how-help:"specify how many visits may wait to be recorded before new ones are dropped"`
+	VisitBatch int    `how-long:"visit-batch" how-env:"WEB_SRV_VISIT_BATCH" how-help:"specify the maximum number of visits recorded at once"`
+	VisitFlush int    `how-long:"visit-flush" how-env:"WEB_SRV_VISIT_FLUSH" how-help:"specify the maximum milliseconds between recording visits"`
+	VisitRules string `how-long:"visit-rules" how-env:"WEB_SRV_VISIT_RULES" how-help:"specify a file of rules for which requests to record as visits - set it empty to record all"`
+
+	VisitIPMode    string `how-long:"visit-ip-mode" how-env:"WEB_SRV_VISIT_IP_MODE" how-help:"specify how visitor IPs are stored: full, truncate (to the network), or hash (keyed, requires the web-srv-visit-ip-key secret)"`
+	VisitRetention int    `how-long:"visit-retention" how-env:"WEB_SRV_VISIT_RETENTION" how-help:"specify the number of days to keep visits (default: forever)"`
+	VisitRollup    bool   `how-long:"visit-rollup" how-env:"WEB_SRV_VISIT_ROLLUP" how-help:"summarize visits per day and path before removing them for retention"`
+	VisitDNT       bool   `how-long:"visit-dnt" how-env:"WEB_SRV_VISIT_DNT" how-help:"don't record visits with Do Not Track or Global Privacy Control set"`
+
+	GeoIPDB     string `how-long:"geoip-db" how-env:"WEB_SRV_GEOIP_DB" how-help:"specify a MaxMind format (ie: GeoLite2 Country) database file to look up the countries of visitors in"`
+	GeoIPReload int    `how-long:"geoip-reload" how-env:"WEB_SRV_GEOIP_RELOAD" how-help:"specify the seconds between checking the GeoIP database file for updates"`
+
+	LogLevel  string `how-long:"log-level" how-env:"WEB_SRV_LOG_LEVEL" how-help:"specify the minimum level of logs to write: debug, info, warn, or error"`
+	LogLevels string `how-long:"log-levels" how-env:"WEB_SRV_LOG_LEVELS" how-help:"specify comma separated package=level overrides of log-level (ie: db=debug,visitors=warn)"`
+	LogFormat string `how-long:"log-format" how-env:"WEB_SRV_LOG_FORMAT" how-help:"specify the format of logs: text, or json (for log shippers)"`
+
+	LogMaxSize  int  `how-long:"log-max-size" how-env:"WEB_SRV_LOG_MAX_SIZE" how-help:"specify the megabytes a log file may grow to before it's rotated (0: no limit)"`
+	LogRotate   int  `how-long:"log-rotate" how-env:"WEB_SRV_LOG_ROTATE" how-help:"specify the hours between rotating log files (0: only by size)"`
+	LogMaxAge   int  `how-long:"log-max-age" how-env:"WEB_SRV_LOG_MAX_AGE" how-help:"specify the days to keep rotated log files (0: forever)"`
+	LogMaxFiles int  `how-long:"log-max-files" how-env:"WEB_SRV_LOG_MAX_FILES" how-help:"specify the number of rotated log files to keep (0: all)"`
+	LogCompress bool `how-long:"log-compress" how-env:"WEB_SRV_LOG_COMPRESS" how-help:"gzip rotated log files"`
+
+	AccessLog string `how-long:"access-log" how-env:"WEB_SRV_ACCESS_LOG" how-help:"specify the format of logs/access.log: combined (Apache's), json, or off"`
+
+	TraceEndpoint string `how-long:"trace-endpoint" how-env:"WEB_SRV_TRACE_ENDPOINT" how-help:"specify the URL of an OTLP/HTTP collector to export traces to (ie: http://collector:4318/v1/traces) (default: don't trace)"`
+	TraceName     string `how-long:"trace-name" how-env:"WEB_SRV_TRACE_NAME" how-help:"specify the service name traces are exported as"`
+
+	ShutdownDrain int `how-long:"shutdown-drain" how-env:"WEB_SRV_SHUTDOWN_DRAIN" how-help:"specify the seconds to report not ready (at /readyz) for before shutting down, for load balancers to stop sending requests"`
+
+	MetricsAddr string `how-long:"metrics-addr" how-env:"WEB_SRV_METRICS_ADDR" how-help:"specify the address (ie: :9100) to serve Prometheus metrics on, at /metrics - keep it off the public network (default: don't serve them)"`
+
+	TrustedProxies string `how-long:"trusted-proxies" how-env:"WEB_SRV_TRUSTED_PROXIES" how-help:"specify comma separated CIDRs of proxies (ie: the swarm ingress network) whose proxy header to believe"`
+	ProxyHeader    string `how-long:"trusted-proxy-header" how-env:"WEB_SRV_TRUSTED_PROXY_HEADER" how-help:"specify the one header trusted proxies set the client's address in: X-Forwarded-For, X-Real-IP, or Forwarded"`
 }
 
 func DefaultConfig() Config {
@@ -26,5 +90,28 @@ func DefaultConfig() Config {
 		SessionTTL: 0,
 		CertRenew:  certRenew,
 		CertEmail:  certEmail,
+		OIDCScope:  oidcScope,
+		VisitQueue: visitQueue,
+		VisitBatch: visitBatch,
+		VisitFlush: visitFlush,
+
+		VisitRules:  visitRules,
+		VisitIPMode: visitIPMode,
+		VisitDNT:    visitDNT,
+
+		GeoIPReload: geoIPReload,
+
+		LogLevel:    logLevel,
+		LogFormat:   logFormat,
+		LogMaxSize:  logMaxSize,
+		LogRotate:   logRotate,
+		LogMaxAge:   logMaxAge,
+		LogCompress: logCompress,
+		AccessLog:   accessLog,
+		TraceName:   traceName,
+
+		ShutdownDrain: shutdownDrain,
+
+		ProxyHeader: proxyHeader,
 	}
 }
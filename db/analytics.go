@@ -0,0 +1,255 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	ErrUnknownBucket    = errors.New("unknown time bucket")
+	ErrUnknownDimension = errors.New("unknown visit dimension")
+	ErrUnknownClass     = errors.New("unknown visit class")
+)
+
+const (
+	bucketLayout = "2006-01-02 15:04:05"
+
+	// visit class filter for everything but ClassHuman
+	classAutomated = "automated"
+)
+
+var (
+	// visit time bucketing, in terms of "local": the visit's time in the requested time zone
+	bucketExprs = map[string]string{
+		"hour": "date_format(local, '%Y-%m-%d %H:00:00')",
+		"day":  "date_format(local, '%Y-%m-%d 00:00:00')",
+		"week": "date_format(local - interval weekday(local) day, '%Y-%m-%d 00:00:00')",
+	}
+
+	// visit columns that can be ranked
+	dimensionColumns = map[string]string{
+		"path":      "path",
+		"referrer":  "referrer",
+		"userAgent": "userAgent",
+		"country":   "country",
+		"region":    "concat(country, '-', region)",
+	}
+)
+
+// classCondition restricts a query on visits to class: "" for every visit, "automated" for any but ClassHuman, or one of
+// the Class constants. It is meant to be appended to an existing where clause.
+func classCondition(class string) (condition string, args []interface{}, err error) {
+	switch class {
+	case "":
+
+	case classAutomated:
+		condition = " and class <> ?"
+		args = []interface{}{ClassHuman}
+
+	case ClassHuman, ClassBot, ClassHeadless:
+		condition = " and class = ?"
+		args = []interface{}{class}
+
+	default:
+		err = ErrUnknownClass
+	}
+	return
+}
+
+// VisitsTraffic counts page views, unique visitors (by IP), and errors per bucket ("hour", "day", or "week").
+// Buckets are aligned to location, following its changes of offset (ie: daylight saving time) over the range.
+func VisitsTraffic(ctx context.Context, start, end time.Time, bucket string, location *time.Location) (results []TrafficBucket, err error) {
+	bucketExpr, ok := bucketExprs[bucket]
+	if !ok {
+		err = ErrUnknownBucket
+		return
+	}
+
+	conn, ok := ctx.Value(connKey{}).(*sql.Conn)
+	if !ok {
+		err = ErrNoDB
+		return
+	}
+
+	offsetExpr, offsetArgs := offsetExpr(start, end, location)
+
+	args := []interface{}{ClassHuman}
+	args = append(args, offsetArgs...)
+	args = append(args, start, end)
+
+	rows, err := conn.QueryContext(ctx,
+		`select `+bucketExpr+` as bucket,
+			count(*),
+			count(distinct ip),
+			coalesce(sum(status between 400 and 499), 0),
+			coalesce(sum(status >= 500), 0),
+			coalesce(sum(class <> ?), 0)
+		from (select time + interval `+offsetExpr+` second as local, ip, status, class from visits where time between ? and ?) v
+		group by bucket
+		order by bucket`,
+		args...)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			b       TrafficBucket
+			bucketT string
+		)
+		err = rows.Scan(&bucketT, &b.Views, &b.Visitors, &b.ClientErrors, &b.ServerErrors, &b.BotViews)
+		if err != nil {
+			return
+		}
+
+		b.Time, err = time.ParseInLocation(bucketLayout, bucketT, location)
+		if err != nil {
+			return
+		}
+		b.ErrorRate = float64(b.ServerErrors) / float64(b.Views)
+		results = append(results, b)
+	}
+	err = rows.Err()
+
+	return
+}
+
+// offsetExpr returns an expression of a visit's offset from UTC in location, in seconds, for visits between start and
+// end. Named zones aren't left to the database, which may not have their rules loaded.
+func offsetExpr(start, end time.Time, location *time.Location) (expr string, args []interface{}) {
+	_, offset := start.In(location).Zone()
+
+	expr = "case"
+	for from := start; from.Before(end); {
+		to := from.Add(24 * time.Hour)
+		if to.After(end) {
+			to = end
+		}
+
+		if _, next := to.In(location).Zone(); next == offset {
+			from = to
+			continue
+		}
+
+		// narrow the day down to the second the offset changed at - offsets only change on whole seconds
+		from = from.Truncate(time.Second)
+		for to.Sub(from) > time.Second {
+			mid := from.Add((to.Sub(from) / 2).Truncate(time.Second))
+			if _, o := mid.In(location).Zone(); o == offset {
+				from = mid
+			} else {
+				to = mid
+			}
+		}
+		to = from.Add(time.Second)
+
+		expr += " when time < ? then ?"
+		args = append(args, to, offset)
+
+		from = to
+		_, offset = to.In(location).Zone()
+	}
+
+	if len(args) == 0 {
+		return "?", []interface{}{offset}
+	}
+
+	expr += " else ? end"
+	args = append(args, offset)
+	return "(" + expr + ")", args
+}
+
+// VisitsTop ranks the most common values of dimension ("path", "referrer", "userAgent", "country", or "region") between
+// start and end, of
+// class (see classCondition).
+func VisitsTop(ctx context.Context, dimension string, start, end time.Time, class string, limit int) (results []TopEntry, err error) {
+	column, ok := dimensionColumns[dimension]
+	if !ok {
+		err = ErrUnknownDimension
+		return
+	}
+
+	condition, args, err := classCondition(class)
+	if err != nil {
+		return
+	}
+
+	conn, ok := ctx.Value(connKey{}).(*sql.Conn)
+	if !ok {
+		err = ErrNoDB
+		return
+	}
+
+	rows, err := conn.QueryContext(ctx,
+		fmt.Sprintf(`select %[1]s, count(*) as hits
+		from visits
+		where time between ? and ? and %[1]s is not null%[2]s
+		group by %[1]s
+		order by hits desc
+		limit ?`, column, condition),
+		append(append([]interface{}{start, end}, args...), limit)...)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e TopEntry
+		err = rows.Scan(&e.Value, &e.Count)
+		if err != nil {
+			return
+		}
+		results = append(results, e)
+	}
+	err = rows.Err()
+
+	return
+}
+
+// VisitsCampaigns summarizes the visits between start and end, of class (see classCondition), per utm_source,
+// utm_medium, and utm_campaign, most visited first. Visits without any utm_* parameters are left out.
+func VisitsCampaigns(ctx context.Context, start, end time.Time, class string, limit int) (results []CampaignStats, err error) {
+	condition, args, err := classCondition(class)
+	if err != nil {
+		return
+	}
+
+	conn, ok := ctx.Value(connKey{}).(*sql.Conn)
+	if !ok {
+		err = ErrNoDB
+		return
+	}
+
+	rows, err := conn.QueryContext(ctx,
+		`select coalesce(utmSource, ''), coalesce(utmMedium, ''), coalesce(utmCampaign, ''),
+			count(*) as views,
+			count(distinct ip),
+			count(distinct user)
+		from visits
+		where time between ? and ?
+			and (utmSource is not null or utmMedium is not null or utmCampaign is not null)`+condition+`
+		group by utmSource, utmMedium, utmCampaign
+		order by views desc
+		limit ?`,
+		append(append([]interface{}{start, end}, args...), limit)...)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var c CampaignStats
+		err = rows.Scan(&c.Source, &c.Medium, &c.Name, &c.Views, &c.Visitors, &c.Users)
+		if err != nil {
+			return
+		}
+		results = append(results, c)
+	}
+	err = rows.Err()
+
+	return
+}
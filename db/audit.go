@@ -0,0 +1,108 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+)
+
+const (
+	auditMaxLimit = 10000
+)
+
+func AuditAdd(ctx context.Context, event *AuditEvent) (err error) {
+	conn, ok := ctx.Value(connKey{}).(*sql.Conn)
+	if !ok {
+		err = ErrNoDB
+		return
+	}
+
+	var metadata interface{}
+	if event.Metadata != "" {
+		metadata = event.Metadata
+	}
+
+	_, err = conn.ExecContext(ctx,
+		"insert into audit_events (time, actor, target, action, ip, metadata) values (?, ?, ?, ?, ?, ?)",
+		event.Time, nullString(event.Actor), nullString(event.Target), event.Action, event.IP, metadata)
+	return
+}
+
+// AuditEvents returns the events matching filter, newest first.
+func AuditEvents(ctx context.Context, filter *AuditFilter, location *time.Location) (results []AuditEvent, err error) {
+	conn, ok := ctx.Value(connKey{}).(*sql.Conn)
+	if !ok {
+		err = ErrNoDB
+		return
+	}
+
+	var (
+		where []string
+		args  []interface{}
+	)
+
+	if filter.Actor != "" {
+		where = append(where, "actor = ?")
+		args = append(args, filter.Actor)
+	}
+	if filter.Target != "" {
+		where = append(where, "target = ?")
+		args = append(args, filter.Target)
+	}
+	if filter.Action != "" {
+		where = append(where, "action = ?")
+		args = append(args, filter.Action)
+	}
+	if len(filter.Actions) != 0 {
+		where = append(where, "action in (?"+strings.Repeat(", ?", len(filter.Actions)-1)+")")
+		for _, action := range filter.Actions {
+			args = append(args, action)
+		}
+	}
+	if !filter.Start.IsZero() {
+		where = append(where, "time >= ?")
+		args = append(args, filter.Start)
+	}
+	if !filter.End.IsZero() {
+		where = append(where, "time <= ?")
+		args = append(args, filter.End)
+	}
+
+	limit := filter.Limit
+	if limit <= 0 || limit > auditMaxLimit {
+		limit = auditMaxLimit
+	}
+
+	query := "select id, time, actor, target, action, ip, metadata from audit_events"
+	if len(where) != 0 {
+		query += " where " + strings.Join(where, " and ")
+	}
+	query += " order by time desc, id desc limit ?"
+	args = append(args, limit)
+
+	rows, err := conn.QueryContext(ctx, query, args...)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			e                       AuditEvent
+			actor, target, metadata sql.NullString
+		)
+		err = rows.Scan(&e.ID, &e.Time, &actor, &target, &e.Action, &e.IP, &metadata)
+		if err != nil {
+			return
+		}
+		e.Actor = actor.String
+		e.Target = target.String
+		e.Metadata = metadata.String
+		e.Time = e.Time.In(location)
+		results = append(results, e)
+	}
+	err = rows.Err()
+
+	return
+}
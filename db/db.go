@@ -16,13 +16,15 @@ type connKey struct{}
 var (
 	ErrNoDB = errors.New("no db connection")
 	handle  *sql.DB
+
+	logger = logme.For("db")
 )
 
 func Middleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		conn, err := handle.Conn(r.Context())
 		if err != nil {
-			logme.Err().Println("error getting db connection:", err)
+			logger.Ctx(r.Context()).Error("getting db connection", "err", err)
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
@@ -33,11 +35,27 @@ func Middleware(next http.Handler) http.Handler {
 	})
 }
 
+// Release returns the connection Middleware took for ctx's request to the pool early, for long running handlers (ie:
+// streams) that are done with the db, so they don't hold a connection for as long as they run.
+// Queries made with ctx afterwards fail.
+func Release(ctx context.Context) {
+	if conn, ok := ctx.Value(connKey{}).(*sql.Conn); ok {
+		conn.Close()
+	}
+}
+
 func Open(dbAddr, driver string) (err error) {
-	handle, err = sql.Open(driver, dbAddr+"?parseTime=true")
+	dsn := dbAddr + "?parseTime=true"
+
+	// just to find the driver
+	raw, err := sql.Open(driver, dsn)
 	if err != nil {
 		return
 	}
+	drv := raw.Driver()
+	raw.Close()
+
+	handle = sql.OpenDB(&tracedConnector{drv: drv, dsn: dsn})
 
 	err = handle.Ping()
 	if err != nil {
@@ -47,6 +65,19 @@ func Open(dbAddr, driver string) (err error) {
 	return
 }
 
+// Ping checks the db can be reached.
+func Ping(ctx context.Context) error {
+	if handle == nil {
+		return ErrNoDB
+	}
+	return handle.PingContext(ctx)
+}
+
+// Stats returns statistics of the db connection pool.
+func Stats() sql.DBStats {
+	return handle.Stats()
+}
+
 func Close() (err error) {
 	if handle != nil {
 		err = handle.Close()
@@ -0,0 +1,88 @@
+package db
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"io"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	ErrIdentityNotExist = errors.New("external identity is not linked to a user")
+)
+
+func IdentityUser(ctx context.Context, issuer, subject string) (username string, err error) {
+	conn, ok := ctx.Value(connKey{}).(*sql.Conn)
+	if !ok {
+		err = ErrNoDB
+		return
+	}
+
+	err = conn.QueryRowContext(ctx, "select user from identities where issuer = ? and subject = ?", issuer, subject).Scan(&username)
+	if err == sql.ErrNoRows {
+		err = ErrIdentityNotExist
+	}
+	return
+}
+
+func IdentityLink(ctx context.Context, issuer, subject, username string) (err error) {
+	conn, ok := ctx.Value(connKey{}).(*sql.Conn)
+	if !ok {
+		err = ErrNoDB
+		return
+	}
+
+	_, err = conn.ExecContext(ctx, "insert into identities (issuer, subject, user, created) values (?, ?, ?, ?)", issuer, subject, username, time.Now().UTC())
+	return
+}
+
+// UserNewExternal creates a user who signs in with an external identity, and links the identity to them.
+// The user is given a random password, so they can't log in with one until they change it.
+func UserNewExternal(ctx context.Context, username, email, issuer, subject string) (err error) {
+	password := make([]byte, 32)
+	_, err = io.ReadFull(rand.Reader, password)
+	if err != nil {
+		return
+	}
+
+	hashed, err := bcrypt.GenerateFromPassword(password, bcrypt.DefaultCost)
+	if err != nil {
+		return
+	}
+
+	conn, ok := ctx.Value(connKey{}).(*sql.Conn)
+	if !ok {
+		err = ErrNoDB
+		return
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+	}()
+
+	result, err := tx.ExecContext(ctx, "insert into users (name, email, password, enabled) values (?, ?, ?, ?)", username, email, hashed, true)
+	if err != nil {
+		return
+	}
+
+	affected, err := result.RowsAffected()
+	if err == nil && affected == 0 {
+		err = ErrUserExist
+		return
+	}
+
+	_, err = tx.ExecContext(ctx, "insert into identities (issuer, subject, user, created) values (?, ?, ?, ?)", issuer, subject, username, time.Now().UTC())
+	return
+}
@@ -0,0 +1,91 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Migrate applies each *.sql file in dir, in name order, that hasn't been applied yet.
+// Each file is applied in a transaction, and recorded in the migrations table.
+// Statements within a file are separated by a ';' at the end of a line, and lines starting with "--" are ignored.
+// MySQL implicitly commits DDL statements, so migrations should be safe to re-run (ie: "if not exists").
+func Migrate(ctx context.Context, dir string) (err error) {
+	_, err = handle.ExecContext(ctx, "create table if not exists migrations (name varchar(255) primary key, applied datetime not null)")
+	if err != nil {
+		return
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.sql"))
+	if err != nil {
+		return
+	}
+	sort.Strings(files)
+
+	for _, file := range files {
+		name := filepath.Base(file)
+
+		var applied time.Time
+		err = handle.QueryRowContext(ctx, "select applied from migrations where name = ?", name).Scan(&applied)
+		if err == nil {
+			continue
+		}
+		if err != sql.ErrNoRows {
+			return
+		}
+
+		var buf []byte
+		buf, err = ioutil.ReadFile(file)
+		if err != nil {
+			return
+		}
+
+		err = migrate(ctx, name, string(buf))
+		if err != nil {
+			return
+		}
+		logger.Info("applied migration", "name", name)
+	}
+
+	return nil
+}
+
+func migrate(ctx context.Context, name, script string) (err error) {
+	tx, err := handle.BeginTx(ctx, nil)
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+	}()
+
+	var lines []string
+	for _, line := range strings.Split(script, "\n") {
+		if !strings.HasPrefix(strings.TrimSpace(line), "--") {
+			lines = append(lines, line)
+		}
+	}
+
+	for _, stmt := range strings.Split(strings.Join(lines, "\n"), ";\n") {
+		stmt = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(stmt), ";"))
+		if stmt == "" {
+			continue
+		}
+
+		_, err = tx.ExecContext(ctx, stmt)
+		if err != nil {
+			return
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, "insert into migrations (name, applied) values (?, ?)", name, time.Now().UTC())
+	return
+}
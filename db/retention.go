@@ -0,0 +1,77 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// VisitsOldest returns the time of the oldest visit. It is the zero time if there are none.
+// Like the other retention functions, it uses its own connection, rather than a request's.
+func VisitsOldest(ctx context.Context) (oldest time.Time, err error) {
+	if handle == nil {
+		err = ErrNoDB
+		return
+	}
+
+	var t mysql.NullTime
+	err = handle.QueryRowContext(ctx, "select min(time) from visits").Scan(&t)
+	oldest = t.Time
+	return
+}
+
+// VisitsRollupDay summarizes the visits of the (UTC) day starting at day into visit_rollups, unless an earlier call
+// already did, and was interrupted before they were all deleted. It doesn't delete them - see VisitsDeleteBefore.
+func VisitsRollupDay(ctx context.Context, day time.Time) (err error) {
+	if handle == nil {
+		err = ErrNoDB
+		return
+	}
+
+	end := day.AddDate(0, 0, 1)
+
+	tx, err := handle.BeginTx(ctx, nil)
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+	}()
+
+	var done bool
+	err = tx.QueryRowContext(ctx, "select exists(select 1 from visit_rollups where day = date(?))", day).Scan(&done)
+	if err != nil || done {
+		return
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`insert into visit_rollups (day, path, views, visitors, bots)
+		select date(time), path, count(*), count(distinct ip), sum(class <> ?)
+		from visits
+		where time >= ? and time < ?
+		group by date(time), path
+		on duplicate key update views = views + values(views), visitors = visitors + values(visitors),
+			bots = bots + values(bots)`,
+		ClassHuman, day, end)
+	return
+}
+
+// VisitsDeleteBefore deletes up to limit visits older than before, returning how many were.
+func VisitsDeleteBefore(ctx context.Context, before time.Time, limit int) (deleted int64, err error) {
+	if handle == nil {
+		err = ErrNoDB
+		return
+	}
+
+	result, err := handle.ExecContext(ctx, "delete from visits where time < ? limit ?", before, limit)
+	if err != nil {
+		return
+	}
+
+	return result.RowsAffected()
+}
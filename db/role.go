@@ -0,0 +1,213 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+)
+
+var (
+	ErrRoleNotExist       = errors.New("role does not exist")
+	ErrUserOrRoleNotExist = errors.New("user or role does not exist")
+)
+
+// UserPermissions returns the distinct permissions granted to username by all of their roles.
+// Disabled users have no permissions.
+func UserPermissions(ctx context.Context, username string) (perms []string, err error) {
+	conn, ok := ctx.Value(connKey{}).(*sql.Conn)
+	if !ok {
+		err = ErrNoDB
+		return
+	}
+
+	rows, err := conn.QueryContext(ctx,
+		`select distinct rp.permission
+		from user_roles ur
+		join role_permissions rp on rp.role = ur.role
+		join users u on u.name = ur.user
+		where ur.user = ? and u.enabled = true`, username)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var p string
+		err = rows.Scan(&p)
+		if err != nil {
+			return
+		}
+		perms = append(perms, p)
+	}
+	err = rows.Err()
+
+	return
+}
+
+func UserRoles(ctx context.Context, username string) (roles []string, err error) {
+	conn, ok := ctx.Value(connKey{}).(*sql.Conn)
+	if !ok {
+		err = ErrNoDB
+		return
+	}
+
+	rows, err := conn.QueryContext(ctx, "select role from user_roles where user = ? order by role", username)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var role string
+		err = rows.Scan(&role)
+		if err != nil {
+			return
+		}
+		roles = append(roles, role)
+	}
+	err = rows.Err()
+
+	return
+}
+
+func UserAddRole(ctx context.Context, username, role string) (err error) {
+	conn, ok := ctx.Value(connKey{}).(*sql.Conn)
+	if !ok {
+		err = ErrNoDB
+		return
+	}
+
+	result, err := conn.ExecContext(ctx,
+		"insert ignore into user_roles (user, role) select u.name, r.name from users u, roles r where u.name = ? and r.name = ?",
+		username, role)
+	if err != nil {
+		return
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil || affected != 0 {
+		return
+	}
+
+	// either it was already assigned, or one of them doesn't exist
+	var count int
+	err = conn.QueryRowContext(ctx, "select count(*) from user_roles where user = ? and role = ?", username, role).Scan(&count)
+	if err == nil && count == 0 {
+		err = ErrUserOrRoleNotExist
+	}
+
+	return
+}
+
+func UserRemoveRole(ctx context.Context, username, role string) (err error) {
+	conn, ok := ctx.Value(connKey{}).(*sql.Conn)
+	if !ok {
+		err = ErrNoDB
+		return
+	}
+
+	_, err = conn.ExecContext(ctx, "delete from user_roles where user = ? and role = ?", username, role)
+	return
+}
+
+func Roles(ctx context.Context) (roles []Role, err error) {
+	conn, ok := ctx.Value(connKey{}).(*sql.Conn)
+	if !ok {
+		err = ErrNoDB
+		return
+	}
+
+	rows, err := conn.QueryContext(ctx,
+		`select r.name, r.description, rp.permission
+		from roles r
+		left join role_permissions rp on rp.role = r.name
+		order by r.name, rp.permission`)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			name, description string
+			perm              sql.NullString
+		)
+		err = rows.Scan(&name, &description, &perm)
+		if err != nil {
+			return
+		}
+
+		if len(roles) == 0 || roles[len(roles)-1].Name != name {
+			roles = append(roles, Role{Name: name, Description: description})
+		}
+		if perm.Valid {
+			last := &roles[len(roles)-1]
+			last.Permissions = append(last.Permissions, perm.String)
+		}
+	}
+	err = rows.Err()
+
+	return
+}
+
+// RoleSet creates or replaces a role and its permissions.
+func RoleSet(ctx context.Context, role *Role) (err error) {
+	conn, ok := ctx.Value(connKey{}).(*sql.Conn)
+	if !ok {
+		err = ErrNoDB
+		return
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+	}()
+
+	_, err = tx.ExecContext(ctx,
+		"insert into roles (name, description) values (?, ?) on duplicate key update description = values(description)",
+		role.Name, role.Description)
+	if err != nil {
+		return
+	}
+
+	_, err = tx.ExecContext(ctx, "delete from role_permissions where role = ?", role.Name)
+	if err != nil {
+		return
+	}
+
+	for _, p := range role.Permissions {
+		_, err = tx.ExecContext(ctx, "insert ignore into role_permissions (role, permission) values (?, ?)", role.Name, p)
+		if err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+func RoleDelete(ctx context.Context, name string) (err error) {
+	conn, ok := ctx.Value(connKey{}).(*sql.Conn)
+	if !ok {
+		err = ErrNoDB
+		return
+	}
+
+	result, err := conn.ExecContext(ctx, "delete from roles where name = ?", name)
+	if err != nil {
+		return
+	}
+
+	affected, err := result.RowsAffected()
+	if err == nil && affected == 0 {
+		err = ErrRoleNotExist
+	}
+
+	return
+}
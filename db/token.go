@@ -0,0 +1,137 @@
+package db
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"io"
+	"strings"
+	"time"
+)
+
+var (
+	ErrTokenNotExist = errors.New("token does not exist, or has been revoked")
+)
+
+const (
+	// makes leaked tokens easy to recognize (ie: by secret scanners)
+	tokenPrefix    = "wsb_"
+	tokenRandBytes = 32
+)
+
+// TokenNew creates a new API token for username. The returned secret is only ever available here; only its hash is stored.
+func TokenNew(ctx context.Context, username, name string, scopes []string) (token Token, secret string, err error) {
+	buf := make([]byte, tokenRandBytes)
+	_, err = io.ReadFull(rand.Reader, buf)
+	if err != nil {
+		return
+	}
+	secret = tokenPrefix + base64.RawURLEncoding.EncodeToString(buf)
+	hash := sha256.Sum256([]byte(secret))
+
+	conn, ok := ctx.Value(connKey{}).(*sql.Conn)
+	if !ok {
+		err = ErrNoDB
+		return
+	}
+
+	now := time.Now().UTC()
+	result, err := conn.ExecContext(ctx,
+		"insert into tokens (user, name, hash, scopes, created, lastUsed) values (?, ?, ?, ?, ?, ?)",
+		username, name, hash[:], strings.Join(scopes, " "), now, now)
+	if err != nil {
+		return
+	}
+
+	token = Token{
+		User:     username,
+		Name:     name,
+		Scopes:   scopes,
+		Created:  now,
+		LastUsed: now,
+	}
+	token.ID, err = result.LastInsertId()
+	return
+}
+
+// TokenAuthenticate finds the token matching secret, and marks it as used.
+// Tokens belonging to disabled users are treated as non-existent.
+func TokenAuthenticate(ctx context.Context, secret string) (token Token, err error) {
+	conn, ok := ctx.Value(connKey{}).(*sql.Conn)
+	if !ok {
+		err = ErrNoDB
+		return
+	}
+
+	hash := sha256.Sum256([]byte(secret))
+
+	var scopes string
+	err = conn.QueryRowContext(ctx,
+		"select t.id, t.user, t.name, t.scopes, t.created, t.lastUsed from tokens t join users u on u.name = t.user where t.hash = ? and u.enabled = true",
+		hash[:]).Scan(&token.ID, &token.User, &token.Name, &scopes, &token.Created, &token.LastUsed)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			err = ErrTokenNotExist
+		}
+		return
+	}
+	token.Scopes = strings.Fields(scopes)
+
+	token.LastUsed = time.Now().UTC()
+	_, err = conn.ExecContext(ctx, "update tokens set lastUsed = ? where id = ?", token.LastUsed, token.ID)
+	return
+}
+
+func TokensOf(ctx context.Context, username string) (results []Token, err error) {
+	conn, ok := ctx.Value(connKey{}).(*sql.Conn)
+	if !ok {
+		err = ErrNoDB
+		return
+	}
+
+	rows, err := conn.QueryContext(ctx, "select id, user, name, scopes, created, lastUsed from tokens where user = ? order by created", username)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			t      Token
+			scopes string
+		)
+		err = rows.Scan(&t.ID, &t.User, &t.Name, &scopes, &t.Created, &t.LastUsed)
+		if err != nil {
+			return
+		}
+		t.Scopes = strings.Fields(scopes)
+		results = append(results, t)
+	}
+	err = rows.Err()
+
+	return
+}
+
+// TokenRevoke deletes one of username's tokens.
+func TokenRevoke(ctx context.Context, username string, id int64) (err error) {
+	conn, ok := ctx.Value(connKey{}).(*sql.Conn)
+	if !ok {
+		err = ErrNoDB
+		return
+	}
+
+	result, err := conn.ExecContext(ctx, "delete from tokens where id = ? and user = ?", id, username)
+	if err != nil {
+		return
+	}
+
+	affected, err := result.RowsAffected()
+	if err == nil && affected == 0 {
+		err = ErrTokenNotExist
+	}
+
+	return
+}
@@ -1,25 +1,140 @@
 package db
 
 import (
+	"net"
 	"time"
 )
 
+// Visit classes
+const (
+	ClassHuman    = "human"
+	ClassBot      = "bot"      // crawlers, scripts, and anything else that says what it is
+	ClassHeadless = "headless" // automated browsers
+)
+
 type (
 	User struct {
 		Name           string `json:"name"`
 		Email          string `json:"email"`
 		HashedPassword []byte `json:"hashedPassword"`
-		Admin          bool   `json:"admin"`
 		Enabled        bool   `json:"enabled"`
 	}
 
+	Role struct {
+		Name        string   `json:"name"`
+		Description string   `json:"description"`
+		Permissions []string `json:"permissions"`
+	}
+
 	Visit struct {
+		ID        int64     `json:"id"`
 		User      string    `json:"user"`
 		Time      time.Time `json:"time"`
-		IP        string    `json:"ip"`
+		IP        net.IP    `json:"ip"`
 		UserAgent string    `json:"userAgent"`
 		Path      string    `json:"path"`
 		Method    string    `json:"action"`
 		Params    string    `json:"params"`
+		Referrer  string    `json:"referrer"`
+
+		// the user actually behind the visit, if User was being impersonated
+		Impersonator string `json:"impersonator,omitempty"`
+
+		// the response
+		Status   int   `json:"status"`
+		Bytes    int64 `json:"bytes"`
+		Duration int64 `json:"durationUs"` // microseconds
+
+		// who, or what, made the request - one of the Class constants
+		Class string `json:"class"`
+
+		// where the visitor is, if known - ISO 3166 codes
+		Country string `json:"country,omitempty"`
+		Region  string `json:"region,omitempty"`
+
+		// the utm_* parameters the visit had
+		Campaign Campaign `json:"campaign"`
+	}
+
+	// Campaign identifies a source of traffic, as tagged by utm_* parameters
+	Campaign struct {
+		Source  string `json:"source,omitempty"`
+		Medium  string `json:"medium,omitempty"`
+		Name    string `json:"name,omitempty"`
+		Term    string `json:"term,omitempty"`
+		Content string `json:"content,omitempty"`
+	}
+
+	// CampaignStats summarizes the visits of a campaign
+	CampaignStats struct {
+		Source   string `json:"source"`
+		Medium   string `json:"medium"`
+		Name     string `json:"name"`
+		Views    int64  `json:"views"`
+		Visitors int64  `json:"visitors"`
+		Users    int64  `json:"users"` // distinct logged in users
+	}
+
+	// TrafficBucket summarizes the visits in a period of time
+	TrafficBucket struct {
+		Time         time.Time `json:"time"`
+		Views        int64     `json:"views"`
+		Visitors     int64     `json:"visitors"`
+		ClientErrors int64     `json:"clientErrors"`
+		ServerErrors int64     `json:"serverErrors"`
+		BotViews     int64     `json:"botViews"`  // views not by ClassHuman
+		ErrorRate    float64   `json:"errorRate"` // fraction of views that were server errors
+	}
+
+	TopEntry struct {
+		Value string `json:"value"`
+		Count int64  `json:"count"`
+	}
+
+	AuditEvent struct {
+		ID       int64     `json:"id"`
+		Time     time.Time `json:"time"`
+		Actor    string    `json:"actor"`
+		Target   string    `json:"target"`
+		Action   string    `json:"action"`
+		IP       string    `json:"ip"`
+		Metadata string    `json:"metadata"`
+	}
+
+	// VisitFilter selects visits. Zero values match everything, except for Start and End.
+	VisitFilter struct {
+		Start     time.Time
+		End       time.Time
+		Class     string // see classCondition
+		User      string
+		Network   *net.IPNet // ie: a single address is a /32 or /128
+		Path      string     // a prefix, or a glob if it has any '*' or '?'
+		Method    string
+		StatusMin int    // inclusive
+		StatusMax int    // inclusive
+		UserAgent string // a substring
+		After     int64  // only visits with greater IDs (or lesser, if Newest)
+		Limit     int
+		Newest    bool // newest first, rather than oldest
+	}
+
+	// AuditFilter selects audit events. Zero valued fields match everything.
+	AuditFilter struct {
+		Actor   string
+		Target  string
+		Action  string
+		Actions []string // any of
+		Start   time.Time
+		End     time.Time
+		Limit   int
+	}
+
+	Token struct {
+		ID       int64     `json:"id"`
+		User     string    `json:"user"`
+		Name     string    `json:"name"`
+		Scopes   []string  `json:"scopes"`
+		Created  time.Time `json:"created"`
+		LastUsed time.Time `json:"lastUsed"`
 	}
 )
@@ -13,7 +13,7 @@ var (
 	ErrUserDisabledOrNotExist = errors.New("user is disabled, or does not exist")
 )
 
-func UserNew(ctx context.Context, username, password string, admin bool) (err error) {
+func UserNew(ctx context.Context, username, password string) (err error) {
 	hashed, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	if err != nil {
 		return
@@ -25,7 +25,7 @@ func UserNew(ctx context.Context, username, password string, admin bool) (err er
 		return
 	}
 
-	result, err := conn.ExecContext(ctx, "insert into users (name, password, admin, enabled) values (?, ?, ?, ?)", username, hashed, admin, true)
+	result, err := conn.ExecContext(ctx, "insert into users (name, password, enabled) values (?, ?, ?)", username, hashed, true)
 	if err != nil {
 		return
 	}
@@ -112,17 +112,6 @@ func UserChangePassword(ctx context.Context, username, newPassword string) (err
 	return
 }
 
-func UserIsAdmin(ctx context.Context, username string) (admin bool, err error) {
-	conn, ok := ctx.Value(connKey{}).(*sql.Conn)
-	if !ok {
-		err = ErrNoDB
-		return
-	}
-
-	err = conn.QueryRowContext(ctx, "select admin from users where name = ?", username).Scan(&admin)
-	return
-}
-
 func UserIsEnabled(ctx context.Context, username string) (yes bool, err error) {
 	conn, ok := ctx.Value(connKey{}).(*sql.Conn)
 	if !ok {
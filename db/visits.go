@@ -1,11 +1,72 @@
 package db
 
 import (
+	"bytes"
 	"context"
 	"database/sql"
+	"net"
+	"strings"
 	"time"
 )
 
+// columns of visits, in the order of Visit.values
+var visitColumns = []string{
+	"user", "time", "ip", "userAgent", "path", "action", "params", "impersonator",
+	"status", "bytes", "duration", "referrer", "class", "country", "region",
+	"utmSource", "utmMedium", "utmCampaign", "utmTerm", "utmContent",
+}
+
+func (v *Visit) values() []interface{} {
+	return []interface{}{
+		nullString(v.User), v.Time, ipBytes(v.IP), v.UserAgent, v.Path, v.Method, v.Params, nullString(v.Impersonator),
+		v.Status, v.Bytes, v.Duration, nullString(v.Referrer), v.Class,
+		nullString(v.Country), nullString(v.Region),
+		nullString(v.Campaign.Source), nullString(v.Campaign.Medium), nullString(v.Campaign.Name),
+		nullString(v.Campaign.Term), nullString(v.Campaign.Content),
+	}
+}
+
+// scan a row of "id" followed by visitColumns
+func (v *Visit) scan(rows *sql.Rows) error {
+	var (
+		user, impersonator, referrer sql.NullString
+		userAgent, params            sql.NullString
+		country, region              sql.NullString
+		source, medium, campaign     sql.NullString
+		term, content                sql.NullString
+		status, size, duration       sql.NullInt64
+		ip                           []byte
+	)
+
+	err := rows.Scan(&v.ID, &user, &v.Time, &ip, &userAgent, &v.Path, &v.Method, &params, &impersonator,
+		&status, &size, &duration, &referrer, &v.Class,
+		&country, &region,
+		&source, &medium, &campaign, &term, &content)
+	if err != nil {
+		return err
+	}
+
+	v.User = user.String
+	v.IP = scanIP(ip)
+	v.UserAgent = userAgent.String
+	v.Params = params.String
+	v.Impersonator = impersonator.String
+	v.Status = int(status.Int64)
+	v.Bytes = size.Int64
+	v.Duration = duration.Int64
+	v.Referrer = referrer.String
+	v.Country = country.String
+	v.Region = region.String
+	v.Campaign = Campaign{
+		Source:  source.String,
+		Medium:  medium.String,
+		Name:    campaign.String,
+		Term:    term.String,
+		Content: content.String,
+	}
+	return nil
+}
+
 func VisitAdd(ctx context.Context, visit *Visit) (err error) {
 	conn, ok := ctx.Value(connKey{}).(*sql.Conn)
 	if !ok {
@@ -14,27 +75,283 @@ func VisitAdd(ctx context.Context, visit *Visit) (err error) {
 	}
 
 	_, err = conn.ExecContext(ctx,
-		"insert into visits (user, time, ip, userAgent, path, action, params) values (?, ?, ?, ?, ?, ?, ?)",
-		visit.User, visit.Time, visit.IP, visit.UserAgent, visit.Path, visit.Method, visit.Params)
+		"insert into visits ("+strings.Join(visitColumns, ", ")+") values "+visitPlaceholders(1),
+		visit.values()...)
 	return
 }
 
-func VisitsBetween(ctx context.Context, start, end time.Time, location *time.Location) (results []Visit, err error) {
+// VisitsEach calls fn with each visit matching filter, in ID order (or reverse, if filter.Newest), until there are no more or fn returns an error.
+// Visits are read as fn is called, rather than all at once, so fn shouldn't take long - the connection is held until
+// VisitsEach returns. The Visit passed to fn is reused.
+func VisitsEach(ctx context.Context, filter *VisitFilter, location *time.Location, fn func(*Visit) error) (err error) {
+	where, args, err := filter.where()
+	if err != nil {
+		return
+	}
+
 	conn, ok := ctx.Value(connKey{}).(*sql.Conn)
 	if !ok {
 		err = ErrNoDB
 		return
 	}
 
-	rows, err := conn.QueryContext(ctx, "select * from visits where time between ? and ?", start, end)
+	query := "select id, " + strings.Join(visitColumns, ", ") + " from visits where " + where + " order by id"
+	if filter.Newest {
+		query += " desc"
+	}
+	if filter.Limit > 0 {
+		query += " limit ?"
+		args = append(args, filter.Limit)
+	}
+
+	rows, err := conn.QueryContext(ctx, query, args...)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
 
 	var v Visit
 	for rows.Next() {
-		rows.Scan(&v.User, &v.Time, &v.IP, &v.UserAgent, &v.Path, &v.Method, &v.Params)
+		err = v.scan(rows)
+		if err != nil {
+			return
+		}
 		v.Time = v.Time.In(location)
-		results = append(results, v)
+
+		err = fn(&v)
+		if err != nil {
+			return
+		}
 	}
 	err = rows.Err()
 
 	return
 }
+
+func (f *VisitFilter) where() (where string, args []interface{}, err error) {
+	conditions := []string{"time between ? and ?"}
+	args = []interface{}{f.Start, f.End}
+
+	switch {
+	case !f.Newest:
+		conditions = append(conditions, "id > ?")
+		args = append(args, f.After)
+
+	case f.After > 0:
+		conditions = append(conditions, "id < ?")
+		args = append(args, f.After)
+	}
+
+	condition, classArgs, err := classCondition(f.Class)
+	if err != nil {
+		return
+	}
+
+	if f.User != "" {
+		conditions = append(conditions, "user = ?")
+		args = append(args, f.User)
+	}
+	if f.Network != nil {
+		first, last := networkRange(f.Network)
+		conditions = append(conditions, "ip between ? and ?")
+		args = append(args, first, last)
+	}
+	if f.Path != "" {
+		conditions = append(conditions, "path like ?")
+		if strings.ContainsAny(f.Path, "*?") {
+			args = append(args, globToLike(f.Path))
+		} else {
+			args = append(args, escapeLike(f.Path)+"%")
+		}
+	}
+	if f.Method != "" {
+		conditions = append(conditions, "action = ?")
+		args = append(args, f.Method)
+	}
+	if f.StatusMin != 0 {
+		conditions = append(conditions, "status >= ?")
+		args = append(args, f.StatusMin)
+	}
+	if f.StatusMax != 0 {
+		conditions = append(conditions, "status <= ?")
+		args = append(args, f.StatusMax)
+	}
+	if f.UserAgent != "" {
+		conditions = append(conditions, "userAgent like ?")
+		args = append(args, "%"+escapeLike(f.UserAgent)+"%")
+	}
+
+	where = strings.Join(conditions, " and ") + condition
+	args = append(args, classArgs...)
+	return
+}
+
+// Validate checks f for errors that would otherwise only be found by querying with it.
+func (f *VisitFilter) Validate() error {
+	_, _, err := classCondition(f.Class)
+	return err
+}
+
+// Match reports whether v matches all of f but its times, ID, and limit - as f.where would, for visits not yet in the db.
+func (f *VisitFilter) Match(v *Visit) bool {
+	switch f.Class {
+	case "":
+	case classAutomated:
+		if v.Class == ClassHuman {
+			return false
+		}
+	default:
+		if v.Class != f.Class {
+			return false
+		}
+	}
+
+	switch {
+	case f.User != "" && v.User != f.User,
+		f.Network != nil && !f.Network.Contains(v.IP),
+		f.Method != "" && v.Method != f.Method,
+		f.StatusMin != 0 && v.Status < f.StatusMin,
+		f.StatusMax != 0 && v.Status > f.StatusMax,
+		f.UserAgent != "" && !strings.Contains(strings.ToLower(v.UserAgent), strings.ToLower(f.UserAgent)):
+		return false
+	}
+
+	if f.Path != "" {
+		if strings.ContainsAny(f.Path, "*?") {
+			return globMatch(f.Path, v.Path)
+		}
+		return strings.HasPrefix(v.Path, f.Path)
+	}
+
+	return true
+}
+
+// globMatch matches s against a glob of '*' (any characters, including '/') and '?' (a single character), like the
+// "like" pattern of globToLike
+func globMatch(glob, s string) bool {
+	var (
+		g, i         int
+		starG, starI = -1, 0
+	)
+
+	for i < len(s) {
+		switch {
+		case g < len(glob) && glob[g] == '*':
+			starG, starI = g, i
+			g++
+
+		case g < len(glob) && (glob[g] == '?' || glob[g] == s[i]):
+			g++
+			i++
+
+		case starG >= 0:
+			// let the last '*' take one more character
+			starI++
+			g, i = starG+1, starI
+
+		default:
+			return false
+		}
+	}
+
+	for g < len(glob) && glob[g] == '*' {
+		g++
+	}
+	return g == len(glob)
+}
+
+// networkRange returns the first and last addresses of network, as stored in visits.ip
+func networkRange(network *net.IPNet) (first, last []byte) {
+	ip := network.IP.To16()
+	mask := network.Mask
+	if len(mask) == net.IPv4len {
+		// the IPv4 part of an IPv4-mapped address
+		mask = append(net.CIDRMask(96, 128), mask...)
+	}
+
+	first = make([]byte, net.IPv6len)
+	last = make([]byte, net.IPv6len)
+	for i := range ip {
+		first[i] = ip[i] & mask[i]
+		last[i] = ip[i] | ^mask[i]
+	}
+	return
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// escapeLike escapes the wildcards of a "like" pattern, so s is matched literally
+func escapeLike(s string) string {
+	return likeEscaper.Replace(s)
+}
+
+// globToLike converts a glob of '*' (any characters) and '?' (a single character) to a "like" pattern
+func globToLike(glob string) string {
+	return strings.NewReplacer("*", "%", "?", "_").Replace(escapeLike(glob))
+}
+
+// IPs are stored as their 16 byte form - IPv4 addresses as IPv4-mapped IPv6 addresses
+func ipBytes(ip net.IP) []byte {
+	if ip == nil {
+		return []byte{}
+	}
+	return []byte(ip.To16())
+}
+
+// scanIP also accepts the textual "ip:port" form that visits used to be stored as
+func scanIP(buf []byte) net.IP {
+	addr := string(buf)
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+
+	if ip := net.ParseIP(addr); ip != nil {
+		return ip
+	}
+
+	if len(buf) == net.IPv6len {
+		return net.IP(append([]byte(nil), buf...))
+	}
+	return nil
+}
+
+// empty strings are stored as null, ie: for nullable foreign keys
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+// VisitsAdd inserts visits in a single statement. It uses its own connection, rather than a request's.
+func VisitsAdd(ctx context.Context, visits []Visit) (err error) {
+	if len(visits) == 0 {
+		return
+	}
+
+	if handle == nil {
+		err = ErrNoDB
+		return
+	}
+
+	args := make([]interface{}, 0, len(visits)*len(visitColumns))
+	for i := range visits {
+		args = append(args, visits[i].values()...)
+	}
+
+	_, err = handle.ExecContext(ctx,
+		"insert into visits ("+strings.Join(visitColumns, ", ")+") values "+visitPlaceholders(len(visits)),
+		args...)
+	return
+}
+
+// visitPlaceholders returns "(?, ...), ..." for count rows of visitColumns
+func visitPlaceholders(count int) string {
+	row := "(?" + strings.Repeat(", ?", len(visitColumns)-1) + ")"
+
+	buf := bytes.NewBuffer(nil)
+	for i := 0; i < count; i++ {
+		if i != 0 {
+			buf.WriteString(", ")
+		}
+		buf.WriteString(row)
+	}
+	return buf.String()
+}
@@ -2,6 +2,7 @@ package dialogue
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -12,12 +13,18 @@ import (
 	"github.com/syndtr/goleveldb/leveldb/opt"
 
 	"github.com/dabbertorres/web-srv-base/logme"
+	"github.com/dabbertorres/web-srv-base/realip"
+	"github.com/dabbertorres/web-srv-base/trace"
 )
 
 var (
-	ErrSessionNotExist = errors.New("session does not exist")
-	ErrSessionHasUser  = errors.New("session already has a user")
-	ErrAlreadyOpen     = errors.New("db is already open")
+	ErrSessionNotExist  = errors.New("session does not exist")
+	ErrSessionHasUser   = errors.New("session already has a user")
+	ErrAlreadyOpen      = errors.New("db is already open")
+	ErrNotOpen          = errors.New("db is not open")
+	ErrNotLoggedIn      = errors.New("session is not logged in")
+	ErrImpersonating    = errors.New("session is already impersonating a user")
+	ErrNotImpersonating = errors.New("session is not impersonating a user")
 )
 
 const (
@@ -27,6 +34,8 @@ const (
 var (
 	db              *leveldb.DB
 	sessionLifetime time.Duration
+
+	logger = logme.For("dialogue")
 )
 
 func Open(lifetime time.Duration) (err error) {
@@ -40,31 +49,124 @@ func Open(lifetime time.Duration) (err error) {
 	return err
 }
 
+// never a session's key, as cookie values can't hold NUL
+var checkKey = []byte("\x00health")
+
+// Check checks sessions can be written.
+func Check(ctx context.Context) error {
+	if db == nil {
+		return ErrNotOpen
+	}
+
+	err := db.Put(checkKey, []byte(time.Now().UTC().Format(time.RFC3339Nano)), nil)
+	if err != nil {
+		return err
+	}
+	return db.Delete(checkKey, nil)
+}
+
 func Close() error {
 	return db.Close()
 }
 
 func Middleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// already has a request-only session (see WithUser), so don't touch cookies
+		if _, ok := r.Context().Value(sessionCtxKey{}).(*session); ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		start := time.Now()
 		sess, err := getSession(r)
 		if err != nil {
 			sess, err = newSession(w, r)
+			trace.Record(r.Context(), "session load", trace.KindInternal, start, err, "session.new", true)
 
 			// if we have issues creating sessions, nothing is going to work, so just respond saying we have issues
 			if err != nil {
-				logme.Err().Println("creating new session:", err)
+				logger.Ctx(r.Context()).Error("creating new session", "err", err)
 				w.WriteHeader(http.StatusInternalServerError)
 				return
 			}
+		} else {
+			trace.Record(r.Context(), "session load", trace.KindInternal, start, nil, "session.new", false)
+		}
+
+		sess.LastSeen = time.Now().UTC()
+		if ip := realip.String(r); ip != "" {
+			sess.IPAddr = ip
+		}
+
+		ctx := context.WithValue(r.Context(), sessionCtxKey{}, &sess)
+		if sess.User != "" {
+			ctx = logme.NewContext(ctx, "user", sess.User)
 		}
 
-		r = r.WithContext(context.WithValue(r.Context(), sessionCtxKey{}, &sess))
+		r = r.WithContext(ctx)
 		next.ServeHTTP(w, r)
 
-		setSession(r, &sess)
+		start = time.Now()
+		err = setSession(r, &sess)
+		trace.Record(r.Context(), "session save", trace.KindInternal, start, err)
 	})
 }
 
+// Stats describes the session store.
+type Stats struct {
+	Sessions  int // unexpired
+	LoggedIn  int // unexpired, with a user
+	Expired   int // not yet removed
+	Bytes     int64
+	IORead    uint64
+	IOWrite   uint64
+	Tables    int
+	Delays    int32
+	DelayTime time.Duration
+}
+
+// GetStats counts the sessions in the store, and reads leveldb's stats.
+func GetStats() (stats Stats, err error) {
+	var dbStats leveldb.DBStats
+	err = db.Stats(&dbStats)
+	if err != nil {
+		return
+	}
+
+	stats.IORead = dbStats.IORead
+	stats.IOWrite = dbStats.IOWrite
+	stats.Tables = dbStats.OpenedTablesCount
+	stats.Delays = dbStats.WriteDelayCount
+	stats.DelayTime = dbStats.WriteDelayDuration
+	for _, size := range dbStats.LevelSizes {
+		stats.Bytes += size
+	}
+
+	now := time.Now()
+
+	iter := db.NewIterator(nil, nil)
+	defer iter.Release()
+
+	for iter.Next() {
+		var sess session
+		if json.Unmarshal(iter.Value(), &sess) != nil {
+			continue
+		}
+
+		switch {
+		case sess.Expiration.Before(now):
+			stats.Expired++
+		case sess.User != "":
+			stats.LoggedIn++
+			stats.Sessions++
+		default:
+			stats.Sessions++
+		}
+	}
+	err = iter.Error()
+	return
+}
+
 const statsBaseFmt = `sessions leveldb stats:
 	Write Delays:         %d
 	Write Delay Duration: %s
@@ -1,8 +1,11 @@
 package dialogue
 
 import (
+	"context"
 	"net/http"
 	"time"
+
+	"github.com/dabbertorres/web-srv-base/realip"
 )
 
 func Login(r *http.Request, user string) (err error) {
@@ -18,6 +21,9 @@ func Login(r *http.Request, user string) (err error) {
 	}
 
 	sess.User = user
+	sess.LoggedInAt = time.Now().UTC()
+	sess.Permissions = nil
+	sess.PermissionsAt = time.Time{}
 	return
 }
 
@@ -85,3 +91,122 @@ func ExtendExpiration(r *http.Request) error {
 	sess.Expiration = time.Now().Add(sessionLifetime)
 	return nil
 }
+
+// SetAuthRequest remembers the state, nonce, and PKCE verifier of an external login that is in progress.
+func SetAuthRequest(r *http.Request, state, nonce, verifier string) error {
+	sess, ok := r.Context().Value(sessionCtxKey{}).(*session)
+	if !ok {
+		return ErrSessionNotExist
+	}
+
+	sess.AuthState = state
+	sess.AuthNonce = nonce
+	sess.AuthVerifier = verifier
+	return nil
+}
+
+// TakeAuthRequest returns the values stored by SetAuthRequest, and clears them so they can only be used once.
+func TakeAuthRequest(r *http.Request) (state, nonce, verifier string, err error) {
+	sess, ok := r.Context().Value(sessionCtxKey{}).(*session)
+	if !ok {
+		err = ErrSessionNotExist
+		return
+	}
+
+	state, nonce, verifier = sess.AuthState, sess.AuthNonce, sess.AuthVerifier
+	sess.AuthState, sess.AuthNonce, sess.AuthVerifier = "", "", ""
+	return
+}
+
+// WithUser attaches a session for user to r that only lives as long as the request.
+// Middleware will neither read nor set a session cookie for such a request.
+func WithUser(r *http.Request, user string) *http.Request {
+	sess := &session{
+		User:       user,
+		IPAddr:     realip.String(r),
+		Location:   r.RequestURI,
+		Expiration: time.Now(),
+	}
+	return r.WithContext(context.WithValue(r.Context(), sessionCtxKey{}, sess))
+}
+
+// SetPermissions caches the logged in user's permissions in the session.
+func SetPermissions(r *http.Request, perms []string) error {
+	sess, ok := r.Context().Value(sessionCtxKey{}).(*session)
+	if !ok {
+		return ErrSessionNotExist
+	}
+
+	sess.Permissions = perms
+	sess.PermissionsAt = time.Now()
+	return nil
+}
+
+// GetPermissions returns the permissions cached by SetPermissions, and when they were cached.
+// cachedAt is the zero time if nothing is cached.
+func GetPermissions(r *http.Request) (perms []string, cachedAt time.Time, err error) {
+	sess, ok := r.Context().Value(sessionCtxKey{}).(*session)
+	if !ok {
+		err = ErrSessionNotExist
+		return
+	}
+
+	perms = sess.Permissions
+	cachedAt = sess.PermissionsAt
+	return
+}
+
+// Impersonate makes the session act as user, while remembering the logged in user so they can StopImpersonating.
+func Impersonate(r *http.Request, user string) error {
+	sess, ok := r.Context().Value(sessionCtxKey{}).(*session)
+	if !ok {
+		return ErrSessionNotExist
+	}
+
+	if sess.User == "" {
+		return ErrNotLoggedIn
+	}
+
+	if sess.Impersonator != "" {
+		return ErrImpersonating
+	}
+
+	sess.Impersonator = sess.User
+	sess.User = user
+	sess.Permissions = nil
+	sess.PermissionsAt = time.Time{}
+	return nil
+}
+
+// StopImpersonating returns the session to the user that started impersonating, and returns who was being impersonated.
+func StopImpersonating(r *http.Request) (user string, err error) {
+	sess, ok := r.Context().Value(sessionCtxKey{}).(*session)
+	if !ok {
+		err = ErrSessionNotExist
+		return
+	}
+
+	if sess.Impersonator == "" {
+		err = ErrNotImpersonating
+		return
+	}
+
+	user = sess.User
+	sess.User = sess.Impersonator
+	sess.Impersonator = ""
+	sess.Permissions = nil
+	sess.PermissionsAt = time.Time{}
+	return
+}
+
+// Impersonator returns the user that is actually behind the session, if it is impersonating someone.
+func Impersonator(r *http.Request) (impersonating bool, impersonator string) {
+	sess, ok := r.Context().Value(sessionCtxKey{}).(*session)
+	if !ok {
+		return
+	}
+
+	impersonator = sess.Impersonator
+	impersonating = impersonator != ""
+	return
+}
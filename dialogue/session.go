@@ -10,7 +10,7 @@ import (
 	"net/http"
 	"time"
 
-	"github.com/dabbertorres/web-srv-base/logme"
+	"github.com/dabbertorres/web-srv-base/realip"
 )
 
 const (
@@ -24,6 +24,23 @@ type session struct {
 	IPAddr     string    `json:"ipAddr"`
 	Location   string    `json:"location"`
 	Expiration time.Time `json:"ttl"`
+
+	UserAgent  string    `json:"userAgent,omitempty"`
+	Created    time.Time `json:"created"`
+	LoggedInAt time.Time `json:"loggedInAt"`
+	LastSeen   time.Time `json:"lastSeen"`
+
+	// the user who is acting as User, if any
+	Impersonator string `json:"impersonator,omitempty"`
+
+	// in-flight external (OIDC) login, see SetAuthRequest
+	AuthState    string `json:"authState,omitempty"`
+	AuthNonce    string `json:"authNonce,omitempty"`
+	AuthVerifier string `json:"authVerifier,omitempty"`
+
+	// cached, see SetPermissions
+	Permissions   []string  `json:"permissions,omitempty"`
+	PermissionsAt time.Time `json:"permissionsAt"`
 }
 
 func newSession(w http.ResponseWriter, r *http.Request) (sess session, err error) {
@@ -32,13 +49,15 @@ func newSession(w http.ResponseWriter, r *http.Request) (sess session, err error
 		return
 	}
 
-	sess.IPAddr = r.RemoteAddr
+	sess.IPAddr = realip.String(r)
 	sess.Location = r.RequestURI
 	sess.Expiration = time.Now().Add(sessionLifetime)
+	sess.UserAgent = r.UserAgent()
+	sess.Created = time.Now().UTC()
 
 	buf, err := json.Marshal(&sess)
 	if err != nil {
-		logme.Warn().Printf("marshaling session: %v\n%v\n", err, sess)
+		logger.Ctx(r.Context()).Warn("marshaling session", "err", err, "user", sess.User)
 		return
 	}
 
@@ -100,13 +119,13 @@ func getSession(r *http.Request) (sess session, err error) {
 
 	rawVal, err := db.Get([]byte(cookie.Value), nil)
 	if err != nil {
-		logme.Info().Println("session does not actually exist")
+		logger.Ctx(r.Context()).Debug("session does not actually exist")
 		return
 	}
 
 	err = json.Unmarshal(rawVal, &sess)
 	if err != nil {
-		logme.Warn().Printf("unmarshaling session: %v\n%s\n", err, string(rawVal))
+		logger.Ctx(r.Context()).Warn("unmarshaling session", "err", err, "session", string(rawVal))
 		return
 	}
 
@@ -143,7 +162,7 @@ func genKey(r *http.Request) (key []byte, err error) {
 	)
 
 	buf := bytes.NewBuffer(nil)
-	buf.WriteString(r.RemoteAddr)
+	buf.WriteString(realip.String(r))
 	buf.WriteString(r.UserAgent())
 
 	n, err := buf.ReadFrom(io.LimitReader(rand.Reader, keyRandBytes))
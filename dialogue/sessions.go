@@ -0,0 +1,99 @@
+package dialogue
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// SessionInfo describes a logged in session, without anything that could be used to take it over.
+type SessionInfo struct {
+	ID         string    `json:"id"` // stable, but not the session's key
+	IP         string    `json:"ip"`
+	UserAgent  string    `json:"userAgent"`
+	Created    time.Time `json:"created"`
+	LoggedInAt time.Time `json:"loggedInAt"`
+	LastSeen   time.Time `json:"lastSeen"`
+	Expiration time.Time `json:"expiration"`
+
+	// whether it's the session of the request that asked
+	Current bool `json:"current"`
+
+	// the user actually using it, if user is being impersonated
+	Impersonator string `json:"impersonator,omitempty"`
+}
+
+// Sessions returns the unexpired sessions user is logged in to, most recently used first.
+// r's session is marked as Current, if it is one of them.
+func Sessions(r *http.Request, user string) (sessions []SessionInfo, err error) {
+	var current string
+	if cookie, err := r.Cookie(sessionCookie); err == nil {
+		current = sessionID([]byte(cookie.Value))
+	}
+
+	now := time.Now()
+
+	iter := db.NewIterator(nil, nil)
+	defer iter.Release()
+
+	for iter.Next() {
+		var sess session
+		if json.Unmarshal(iter.Value(), &sess) != nil {
+			continue
+		}
+		if sess.User != user || sess.Expiration.Before(now) {
+			continue
+		}
+
+		id := sessionID(iter.Key())
+		sessions = append(sessions, SessionInfo{
+			ID:           id,
+			IP:           sess.IPAddr,
+			UserAgent:    sess.UserAgent,
+			Created:      sess.Created,
+			LoggedInAt:   sess.LoggedInAt,
+			LastSeen:     sess.LastSeen,
+			Expiration:   sess.Expiration,
+			Current:      id == current,
+			Impersonator: sess.Impersonator,
+		})
+	}
+	err = iter.Error()
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastSeen.After(sessions[j].LastSeen)
+	})
+	return
+}
+
+func sessionID(key []byte) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:8])
+}
+
+// Revoke logs user out of their session identified by id (as listed by Sessions).
+// ErrSessionNotExist is returned if user has no such session.
+func Revoke(user, id string) (err error) {
+	iter := db.NewIterator(nil, nil)
+	defer iter.Release()
+
+	for iter.Next() {
+		if sessionID(iter.Key()) != id {
+			continue
+		}
+
+		var sess session
+		if json.Unmarshal(iter.Value(), &sess) != nil || sess.User != user {
+			continue
+		}
+
+		return db.Delete(iter.Key(), nil)
+	}
+	if err = iter.Error(); err != nil {
+		return
+	}
+	return ErrSessionNotExist
+}
@@ -0,0 +1,141 @@
+package geoip
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/dabbertorres/web-srv-base/logme"
+)
+
+var (
+	mutex   sync.RWMutex
+	current *mmdb
+
+	stop chan struct{}
+
+	logger = logme.For("geoip")
+)
+
+// Open loads the MaxMind format (ie: GeoLite2 Country or City) database at path, replacing any already loaded.
+func Open(path string) error {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	db, err := parseMMDB(buf)
+	if err != nil {
+		return err
+	}
+
+	mutex.Lock()
+	current = db
+	mutex.Unlock()
+	return nil
+}
+
+// Watch reloads the database at path whenever it changes (ie: updated by geoipupdate), checking every interval,
+// until Close is called.
+func Watch(path string, interval time.Duration) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	mutex.Lock()
+	if stop == nil {
+		stop = make(chan struct{})
+	}
+	mutex.Unlock()
+
+	go func(stop <-chan struct{}, modTime time.Time) {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+			case <-stop:
+				return
+			}
+
+			info, err := os.Stat(path)
+			if err != nil {
+				logger.Warn("checking database", "path", path, "err", err)
+				continue
+			}
+			if info.ModTime().Equal(modTime) {
+				continue
+			}
+
+			// keep using the old one if the new one is bad (ie: still being written) - it'll be tried again
+			err = Open(path)
+			if err != nil {
+				logger.Error("reloading database", "path", path, "err", err)
+				continue
+			}
+			modTime = info.ModTime()
+			logger.Info("reloaded database", "path", path)
+		}
+	}(stop, info.ModTime())
+
+	return nil
+}
+
+// Close stops watching for changes, and unloads the database.
+func Close() {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if stop != nil {
+		close(stop)
+		stop = nil
+	}
+	current = nil
+}
+
+// Enabled reports whether a database is loaded.
+func Enabled() bool {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	return current != nil
+}
+
+// Lookup returns the ISO 3166-1 country code of ip, and the ISO 3166-2 code of its region (ie: state or province)
+// without the country prefix, if the database has them. Both are empty if no database is loaded.
+func Lookup(ip net.IP) (country, region string) {
+	mutex.RLock()
+	db := current
+	mutex.RUnlock()
+
+	if db == nil || ip == nil {
+		return
+	}
+
+	value, err := db.lookup(ip)
+	if err != nil {
+		logger.Warn("looking up address", "ip", ip, "err", err)
+		return
+	}
+
+	record, _ := value.(map[string]interface{})
+	country = isoCode(record["country"])
+	if country == "" {
+		// ie: anycast and satellite providers
+		country = isoCode(record["registered_country"])
+	}
+
+	if subdivisions, ok := record["subdivisions"].([]interface{}); ok && len(subdivisions) > 0 {
+		region = isoCode(subdivisions[0])
+	}
+	return
+}
+
+func isoCode(value interface{}) string {
+	m, _ := value.(map[string]interface{})
+	code, _ := m["iso_code"].(string)
+	return code
+}
@@ -0,0 +1,402 @@
+package geoip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+)
+
+// Just enough of the MaxMind DB format (https://maxmind.github.io/MaxMind-DB/) to look up addresses.
+
+var (
+	ErrNoMetadata      = errors.New("no MaxMind DB metadata found")
+	ErrBadMetadata     = errors.New("invalid MaxMind DB metadata")
+	ErrUnsupportedDB   = errors.New("unsupported MaxMind DB record size")
+	ErrCorruptDB       = errors.New("corrupt MaxMind DB")
+	ErrUnsupportedType = errors.New("unsupported MaxMind DB data type")
+)
+
+var metadataMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+const (
+	// the search tree and the data section are separated by 16 zero bytes
+	dataSeparator = 16
+
+	// deepest nested data we'll decode, so a corrupt file can't recurse forever
+	maxDepth = 32
+)
+
+// data types
+const (
+	typeExtended = iota
+	typePointer
+	typeString
+	typeDouble
+	typeBytes
+	typeUint16
+	typeUint32
+	typeMap
+	typeInt32
+	typeUint64
+	typeUint128
+	typeArray
+	typeContainer
+	typeEndMarker
+	typeBool
+	typeFloat
+)
+
+type mmdb struct {
+	buf        []byte
+	data       []byte // the data section
+	nodeCount  uint
+	recordSize uint
+	ipVersion  uint
+	ipv4Start  uint // the node IPv4 addresses start at, in an IPv6 tree
+}
+
+func parseMMDB(buf []byte) (db *mmdb, err error) {
+	markerAt := bytes.LastIndex(buf, metadataMarker)
+	if markerAt < 0 {
+		err = ErrNoMetadata
+		return
+	}
+
+	meta := decoder{buf: buf[markerAt+len(metadataMarker):]}
+	value, _, err := meta.decode(0, 0)
+	if err != nil {
+		return
+	}
+
+	metadata, ok := value.(map[string]interface{})
+	if !ok {
+		err = ErrBadMetadata
+		return
+	}
+
+	db = &mmdb{
+		buf:        buf,
+		nodeCount:  metaUint(metadata, "node_count"),
+		recordSize: metaUint(metadata, "record_size"),
+		ipVersion:  metaUint(metadata, "ip_version"),
+	}
+
+	switch db.recordSize {
+	case 24, 28, 32:
+	default:
+		err = ErrUnsupportedDB
+		return
+	}
+
+	treeSize := db.nodeCount * db.recordSize / 4
+	if treeSize+dataSeparator > uint(markerAt) {
+		err = ErrBadMetadata
+		return
+	}
+	db.data = buf[treeSize+dataSeparator : markerAt]
+
+	if db.ipVersion == 6 {
+		// IPv4 addresses are looked up as ::a.b.c.d - skip the 96 leading zero bits once
+		node := uint(0)
+		for i := 0; i < 96 && node < db.nodeCount; i++ {
+			node, err = db.record(node, 0)
+			if err != nil {
+				return
+			}
+		}
+		db.ipv4Start = node
+	}
+
+	return
+}
+
+func metaUint(metadata map[string]interface{}, key string) uint {
+	switch v := metadata[key].(type) {
+	case uint64:
+		return uint(v)
+	case int32:
+		return uint(v)
+	default:
+		return 0
+	}
+}
+
+// lookup returns the data for ip, or nil if it has none.
+func (db *mmdb) lookup(ip net.IP) (value interface{}, err error) {
+	node := uint(0)
+	bits := ip.To4()
+	if bits != nil {
+		node = db.ipv4Start
+	} else if db.ipVersion == 6 {
+		bits = ip.To16()
+	}
+	if bits == nil {
+		return
+	}
+
+	for i := 0; i < len(bits)*8 && node < db.nodeCount; i++ {
+		bit := uint(bits[i/8]>>(7-uint(i%8))) & 1
+		node, err = db.record(node, bit)
+		if err != nil {
+			return
+		}
+	}
+
+	switch {
+	case node == db.nodeCount:
+		// not found
+
+	case node > db.nodeCount:
+		offset := node - db.nodeCount - dataSeparator
+		dec := decoder{buf: db.data}
+		value, _, err = dec.decode(offset, 0)
+
+	default:
+		err = ErrCorruptDB
+	}
+	return
+}
+
+// record reads the left (bit 0) or right (bit 1) record of node
+func (db *mmdb) record(node, bit uint) (uint, error) {
+	nodeSize := db.recordSize / 4
+	offset := node * nodeSize
+	if offset+nodeSize > uint(len(db.buf)) {
+		return 0, ErrCorruptDB
+	}
+	b := db.buf[offset : offset+nodeSize]
+
+	switch db.recordSize {
+	case 24:
+		b = b[bit*3:]
+		return uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2]), nil
+
+	case 28:
+		if bit == 0 {
+			return uint(b[3]&0xf0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2]), nil
+		}
+		return uint(b[3]&0x0f)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6]), nil
+
+	default:
+		return uint(binary.BigEndian.Uint32(b[bit*4:])), nil
+	}
+}
+
+type decoder struct {
+	buf []byte
+}
+
+// decode the value at offset, returning it and the offset after it.
+// Maps are decoded as map[string]interface{}, and arrays as []interface{}.
+func (d *decoder) decode(offset uint, depth int) (value interface{}, next uint, err error) {
+	if depth > maxDepth {
+		err = ErrCorruptDB
+		return
+	}
+
+	typ, size, offset, err := d.control(offset)
+	if err != nil {
+		return
+	}
+
+	if typ == typePointer {
+		var target uint
+		target, next, err = d.pointer(size, offset)
+		if err != nil {
+			return
+		}
+		value, _, err = d.decode(target, depth+1)
+		return
+	}
+
+	switch typ {
+	case typeMap:
+		m := make(map[string]interface{}, d.capacity(size, offset))
+		next = offset
+		for i := uint(0); i < size; i++ {
+			var key, v interface{}
+			key, next, err = d.decode(next, depth+1)
+			if err != nil {
+				return
+			}
+			v, next, err = d.decode(next, depth+1)
+			if err != nil {
+				return
+			}
+
+			k, ok := key.(string)
+			if !ok {
+				err = ErrCorruptDB
+				return
+			}
+			m[k] = v
+		}
+		value = m
+		return
+
+	case typeArray:
+		a := make([]interface{}, 0, d.capacity(size, offset))
+		next = offset
+		for i := uint(0); i < size; i++ {
+			var v interface{}
+			v, next, err = d.decode(next, depth+1)
+			if err != nil {
+				return
+			}
+			a = append(a, v)
+		}
+		value = a
+		return
+
+	case typeBool:
+		value = size != 0
+		next = offset
+		return
+	}
+
+	next = offset + size
+	if next > uint(len(d.buf)) {
+		err = ErrCorruptDB
+		return
+	}
+	b := d.buf[offset:next]
+
+	switch typ {
+	case typeString:
+		value = string(b)
+
+	case typeBytes:
+		value = append([]byte(nil), b...)
+
+	case typeDouble:
+		if size != 8 {
+			err = ErrCorruptDB
+			return
+		}
+		value = math.Float64frombits(binary.BigEndian.Uint64(b))
+
+	case typeFloat:
+		if size != 4 {
+			err = ErrCorruptDB
+			return
+		}
+		value = math.Float32frombits(binary.BigEndian.Uint32(b))
+
+	case typeUint16, typeUint32, typeUint64:
+		if size > 8 {
+			err = ErrCorruptDB
+			return
+		}
+		var v uint64
+		for _, c := range b {
+			v = v<<8 | uint64(c)
+		}
+		value = v
+
+	case typeInt32:
+		if size > 4 {
+			err = ErrCorruptDB
+			return
+		}
+		var v uint32
+		for _, c := range b {
+			v = v<<8 | uint32(c)
+		}
+		value = int32(v)
+
+	case typeUint128:
+		// nothing we look up uses these
+		value = append([]byte(nil), b...)
+
+	default:
+		err = fmt.Errorf("%v: %d", ErrUnsupportedType, typ)
+	}
+	return
+}
+
+// capacity limits what's allocated up front for a map or array of size elements, whose payload begins at offset, to
+// what could fit in the rest of the buffer, so a corrupt size can't make us allocate more than the database's size.
+func (d *decoder) capacity(size, offset uint) uint {
+	if remaining := uint(len(d.buf)) - offset; size > remaining {
+		return remaining
+	}
+	return size
+}
+
+// control reads the control byte(s) at offset, returning the type and size of the value, and where its payload begins.
+func (d *decoder) control(offset uint) (typ, size, next uint, err error) {
+	if offset >= uint(len(d.buf)) {
+		err = ErrCorruptDB
+		return
+	}
+
+	ctrl := d.buf[offset]
+	next = offset + 1
+	typ = uint(ctrl >> 5)
+
+	if typ == typeExtended {
+		if next >= uint(len(d.buf)) {
+			err = ErrCorruptDB
+			return
+		}
+		typ = 7 + uint(d.buf[next])
+		next++
+	}
+
+	size = uint(ctrl & 0x1f)
+	if typ == typePointer || size < 29 {
+		return
+	}
+
+	extra := size - 28
+	if next+extra > uint(len(d.buf)) {
+		err = ErrCorruptDB
+		return
+	}
+
+	var n uint
+	for _, c := range d.buf[next : next+extra] {
+		n = n<<8 | uint(c)
+	}
+	next += extra
+
+	switch extra {
+	case 1:
+		size = 29 + n
+	case 2:
+		size = 285 + n
+	default:
+		size = 65821 + n
+	}
+	return
+}
+
+// pointer decodes a pointer's target, given the size bits of its control byte.
+func (d *decoder) pointer(size, offset uint) (target, next uint, err error) {
+	length := (size>>3)&0x3 + 1
+	next = offset + length
+	if next > uint(len(d.buf)) {
+		err = ErrCorruptDB
+		return
+	}
+
+	var n uint
+	for _, c := range d.buf[offset:next] {
+		n = n<<8 | uint(c)
+	}
+
+	switch length {
+	case 1:
+		target = (size&0x7)<<8 | n
+	case 2:
+		target = ((size&0x7)<<16 | n) + 2048
+	case 3:
+		target = ((size&0x7)<<24 | n) + 526336
+	default:
+		target = n
+	}
+	return
+}
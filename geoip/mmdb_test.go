@@ -0,0 +1,553 @@
+package geoip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"math"
+	"net"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// encoders for the data section, just enough to write fixtures with
+
+// str encodes a string shorter than 29 bytes.
+func str(s string) []byte {
+	return append([]byte{typeString<<5 | byte(len(s))}, s...)
+}
+
+func uint16Value(v uint16) []byte {
+	return []byte{typeUint16<<5 | 2, byte(v >> 8), byte(v)}
+}
+
+func uint32Value(v uint32) []byte {
+	b := []byte{typeUint32<<5 | 4, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(b[1:], v)
+	return b
+}
+
+// mapOf encodes a map of fewer than 29 entries, given its keys and values in turn.
+func mapOf(entries ...[]byte) []byte {
+	return append([]byte{typeMap<<5 | byte(len(entries)/2)}, bytes.Join(entries, nil)...)
+}
+
+// arrayOf encodes an array of fewer than 29 elements - arrays are an extended type.
+func arrayOf(elems ...[]byte) []byte {
+	return append([]byte{byte(len(elems)), typeArray - 7}, bytes.Join(elems, nil)...)
+}
+
+func TestDecode(t *testing.T) {
+	long := strings.Repeat("x", 65821)
+
+	tests := []struct {
+		name   string
+		buf    []byte
+		offset uint
+		want   interface{}
+		next   uint
+		err    error
+	}{
+		{
+			name: "string",
+			buf:  str("abc"),
+			want: "abc",
+			next: 4,
+		},
+		{
+			name: "empty string",
+			buf:  str(""),
+			want: "",
+			next: 1,
+		},
+		{
+			name: "size 29",
+			buf:  append([]byte{typeString<<5 | 29, 0}, long[:29]...),
+			want: long[:29],
+			next: 31,
+		},
+		{
+			name: "size of one extra byte",
+			buf:  append([]byte{typeString<<5 | 29, 1}, long[:30]...),
+			want: long[:30],
+			next: 32,
+		},
+		{
+			name: "size of two extra bytes",
+			buf:  append([]byte{typeString<<5 | 30, 0, 1}, long[:286]...),
+			want: long[:286],
+			next: 289,
+		},
+		{
+			name: "size of three extra bytes",
+			buf:  append([]byte{typeString<<5 | 31, 0, 0, 0}, long...),
+			want: long,
+			next: 65825,
+		},
+		{
+			name: "truncated size",
+			buf:  []byte{typeString<<5 | 30, 0},
+			err:  ErrCorruptDB,
+		},
+		{
+			name: "truncated payload",
+			buf:  []byte{typeString<<5 | 3, 'a'},
+			err:  ErrCorruptDB,
+		},
+		{
+			name:   "offset past the end",
+			buf:    str("abc"),
+			offset: 4,
+			err:    ErrCorruptDB,
+		},
+		{
+			name:   "at an offset",
+			buf:    append(str("abc"), str("de")...),
+			offset: 4,
+			want:   "de",
+			next:   7,
+		},
+		{
+			name: "bytes",
+			buf:  []byte{typeBytes<<5 | 2, 0xde, 0xad},
+			want: []byte{0xde, 0xad},
+			next: 3,
+		},
+		{
+			name: "double",
+			buf:  []byte{typeDouble<<5 | 8, 0x3f, 0xf8, 0, 0, 0, 0, 0, 0},
+			want: 1.5,
+			next: 9,
+		},
+		{
+			name: "double of the wrong size",
+			buf:  []byte{typeDouble<<5 | 4, 0x3f, 0xf8, 0, 0},
+			err:  ErrCorruptDB,
+		},
+		{
+			name: "uint16",
+			buf:  uint16Value(258),
+			want: uint64(258),
+			next: 3,
+		},
+		{
+			name: "uint32 of fewer bytes",
+			buf:  []byte{typeUint32<<5 | 1, 0xff},
+			want: uint64(255),
+			next: 2,
+		},
+		{
+			name: "uint32 of zero bytes",
+			buf:  []byte{typeUint32 << 5},
+			want: uint64(0),
+			next: 1,
+		},
+		{
+			name: "extended int32",
+			buf:  []byte{4, typeInt32 - 7, 0xff, 0xff, 0xff, 0xfe},
+			want: int32(-2),
+			next: 6,
+		},
+		{
+			name: "extended int32 too big",
+			buf:  []byte{5, typeInt32 - 7, 0, 0, 0, 0, 1},
+			err:  ErrCorruptDB,
+		},
+		{
+			name: "extended uint64",
+			buf:  []byte{8, typeUint64 - 7, 1, 0, 0, 0, 0, 0, 0, 0},
+			want: uint64(1) << 56,
+			next: 10,
+		},
+		{
+			name: "extended uint128",
+			buf:  []byte{2, typeUint128 - 7, 1, 2},
+			want: []byte{1, 2},
+			next: 4,
+		},
+		{
+			name: "extended float",
+			buf:  []byte{4, typeFloat - 7, 0x3f, 0xc0, 0, 0},
+			want: float32(1.5),
+			next: 6,
+		},
+		{
+			name: "extended bool true",
+			buf:  []byte{1, typeBool - 7},
+			want: true,
+			next: 2,
+		},
+		{
+			name: "extended bool false",
+			buf:  []byte{0, typeBool - 7},
+			want: false,
+			next: 2,
+		},
+		{
+			name: "extended array",
+			buf:  arrayOf(str("x"), uint16Value(7)),
+			want: []interface{}{"x", uint64(7)},
+			next: 7,
+		},
+		{
+			name: "truncated extended type",
+			buf:  []byte{1},
+			err:  ErrCorruptDB,
+		},
+		{
+			name: "extended container",
+			buf:  []byte{0, typeContainer - 7},
+			err:  ErrUnsupportedType,
+		},
+		{
+			name: "map",
+			buf:  mapOf(str("k"), mapOf(str("nested"), str("v"))),
+			want: map[string]interface{}{"k": map[string]interface{}{"nested": "v"}},
+			next: 13,
+		},
+		{
+			name: "map key not a string",
+			buf:  mapOf(uint16Value(1), str("v")),
+			err:  ErrCorruptDB,
+		},
+		{
+			name: "map larger than the buffer",
+			buf:  []byte{typeMap<<5 | 31, 0xff, 0xff, 0xff},
+			err:  ErrCorruptDB,
+		},
+		{
+			name: "array larger than the buffer",
+			buf:  []byte{31, typeArray - 7, 0xff, 0xff, 0xff},
+			err:  ErrCorruptDB,
+		},
+		{
+			name:   "pointer",
+			buf:    append(str("abc"), typePointer<<5, 0),
+			offset: 4,
+			want:   "abc",
+			next:   6,
+		},
+		{
+			name: "pointer to itself",
+			buf:  []byte{typePointer << 5, 0},
+			err:  ErrCorruptDB,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := decoder{buf: tt.buf}
+			value, next, err := d.decode(tt.offset, 0)
+			if tt.err != nil {
+				// unsupported types are reported with the type
+				if err == nil || !strings.HasPrefix(err.Error(), tt.err.Error()) {
+					t.Fatalf("decode() = %v, want %v", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("decode() = %v", err)
+			}
+
+			if !reflect.DeepEqual(value, tt.want) {
+				t.Errorf("decode() = %#v, want %#v", value, tt.want)
+			}
+			if next != tt.next {
+				t.Errorf("next = %d, want %d", next, tt.next)
+			}
+		})
+	}
+}
+
+func TestPointer(t *testing.T) {
+	tests := []struct {
+		name   string
+		buf    []byte
+		target uint
+		next   uint
+		err    error
+	}{
+		{
+			name:   "one byte",
+			buf:    []byte{typePointer<<5 | 5, 0x10},
+			target: 5<<8 | 0x10,
+			next:   2,
+		},
+		{
+			name:   "two bytes",
+			buf:    []byte{typePointer<<5 | 1<<3 | 3, 1, 2},
+			target: (3<<16 | 0x0102) + 2048,
+			next:   3,
+		},
+		{
+			name:   "three bytes",
+			buf:    []byte{typePointer<<5 | 2<<3 | 1, 1, 2, 3},
+			target: (1<<24 | 0x010203) + 526336,
+			next:   4,
+		},
+		{
+			// the size's low bits aren't part of a four byte pointer
+			name:   "four bytes",
+			buf:    []byte{typePointer<<5 | 3<<3 | 7, 1, 2, 3, 4},
+			target: 0x01020304,
+			next:   5,
+		},
+		{
+			name: "truncated",
+			buf:  []byte{typePointer<<5 | 1<<3, 1},
+			err:  ErrCorruptDB,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := decoder{buf: tt.buf}
+			typ, size, offset, err := d.control(0)
+			if err != nil || typ != typePointer {
+				t.Fatalf("control() = %d, %v, want a pointer", typ, err)
+			}
+
+			target, next, err := d.pointer(size, offset)
+			if err != tt.err {
+				t.Fatalf("pointer() = %v, want %v", err, tt.err)
+			}
+			if err != nil {
+				return
+			}
+			if target != tt.target || next != tt.next {
+				t.Errorf("pointer() = %d, %d, want %d, %d", target, next, tt.target, tt.next)
+			}
+		})
+	}
+}
+
+func TestRecord(t *testing.T) {
+	tests := []struct {
+		recordSize  uint
+		buf         []byte
+		left, right uint
+	}{
+		{
+			recordSize: 24,
+			buf:        []byte{1, 2, 3, 4, 5, 6},
+			left:       0x010203,
+			right:      0x040506,
+		},
+		{
+			// the middle byte holds the high bits of both records
+			recordSize: 28,
+			buf:        []byte{0x12, 0x34, 0x56, 0xab, 0xcd, 0xef, 0x01},
+			left:       0xa123456,
+			right:      0xbcdef01,
+		},
+		{
+			recordSize: 32,
+			buf:        []byte{1, 2, 3, 4, 0xf5, 6, 7, 8},
+			left:       0x01020304,
+			right:      0xf5060708,
+		},
+	}
+
+	for _, tt := range tests {
+		// a second node, to read at an offset
+		db := &mmdb{
+			buf:        append(make([]byte, len(tt.buf)), tt.buf...),
+			nodeCount:  2,
+			recordSize: tt.recordSize,
+		}
+
+		left, err := db.record(1, 0)
+		if err != nil || left != tt.left {
+			t.Errorf("%d bit records: left = %#x, %v, want %#x", tt.recordSize, left, err, tt.left)
+		}
+		right, err := db.record(1, 1)
+		if err != nil || right != tt.right {
+			t.Errorf("%d bit records: right = %#x, %v, want %#x", tt.recordSize, right, err, tt.right)
+		}
+
+		if _, err := db.record(2, 0); err != ErrCorruptDB {
+			t.Errorf("%d bit records: record past the tree = %v, want %v", tt.recordSize, err, ErrCorruptDB)
+		}
+	}
+}
+
+// buildMMDB builds a database mapping each of networks to its encoded data. Networks mustn't overlap, and IPv6
+// networks are left out of an IPv4 database.
+func buildMMDB(t *testing.T, recordSize, ipVersion uint, networks map[string][]byte) []byte {
+	t.Helper()
+
+	// records are another node's index, -1 if empty, or -2-i for the i'th data
+	var (
+		nodes   = [][2]int{{-1, -1}}
+		data    []byte
+		offsets []int
+	)
+
+	for cidr, value := range networks {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ones, _ := network.Mask.Size()
+
+		bits := network.IP.To4()
+		switch {
+		case bits != nil && ipVersion == 6:
+			// as ::a.b.c.d
+			bits = append(make([]byte, 12), bits...)
+			ones += 96
+		case bits == nil && ipVersion == 4:
+			continue
+		case bits == nil:
+			bits = network.IP.To16()
+		}
+
+		node := 0
+		for i := 0; i < ones; i++ {
+			bit := int(bits[i/8]>>(7-uint(i%8))) & 1
+			if i == ones-1 {
+				nodes[node][bit] = -2 - len(offsets)
+				break
+			}
+
+			if nodes[node][bit] < 0 {
+				nodes[node][bit] = len(nodes)
+				nodes = append(nodes, [2]int{-1, -1})
+			}
+			node = nodes[node][bit]
+		}
+
+		offsets = append(offsets, len(data))
+		data = append(data, value...)
+	}
+
+	nodeCount := len(nodes)
+	recordValue := func(r int) uint32 {
+		switch {
+		case r == -1:
+			return uint32(nodeCount)
+		case r < 0:
+			return uint32(nodeCount + dataSeparator + offsets[-2-r])
+		default:
+			return uint32(r)
+		}
+	}
+
+	var buf []byte
+	for _, node := range nodes {
+		left, right := recordValue(node[0]), recordValue(node[1])
+
+		switch recordSize {
+		case 24:
+			buf = append(buf, byte(left>>16), byte(left>>8), byte(left), byte(right>>16), byte(right>>8), byte(right))
+		case 28:
+			buf = append(buf, byte(left>>16), byte(left>>8), byte(left), byte(left>>24)<<4|byte(right>>24),
+				byte(right>>16), byte(right>>8), byte(right))
+		case 32:
+			buf = append(buf, uint32Value(left)[1:]...)
+			buf = append(buf, uint32Value(right)[1:]...)
+		}
+	}
+
+	buf = append(buf, make([]byte, dataSeparator)...)
+	buf = append(buf, data...)
+	buf = append(buf, metadataMarker...)
+	buf = append(buf, mapOf(
+		str("node_count"), uint32Value(uint32(nodeCount)),
+		str("record_size"), uint16Value(uint16(recordSize)),
+		str("ip_version"), uint16Value(uint16(ipVersion)),
+	)...)
+	return buf
+}
+
+func TestLookup(t *testing.T) {
+	networks := map[string][]byte{
+		"1.2.3.0/24": mapOf(
+			str("country"), mapOf(str("iso_code"), str("NZ")),
+			str("subdivisions"), arrayOf(mapOf(str("iso_code"), str("AUK"))),
+		),
+		"9.0.0.0/8":     mapOf(str("registered_country"), mapOf(str("iso_code"), str("JP"))),
+		"2001:db8::/32": mapOf(str("country"), mapOf(str("iso_code"), str("US"))),
+	}
+
+	tests := []struct {
+		ip              string
+		country, region string
+		ipv6Only        bool
+	}{
+		{ip: "1.2.3.4", country: "NZ", region: "AUK"},
+		{ip: "1.2.3.255", country: "NZ", region: "AUK"},
+		{ip: "1.2.4.1"},
+		{ip: "9.200.1.1", country: "JP"},
+		{ip: "10.0.0.1"},
+		{ip: "2001:db8::1", country: "US", ipv6Only: true},
+		{ip: "2001:db9::1"},
+	}
+
+	t.Cleanup(Close)
+
+	for _, recordSize := range []uint{24, 28, 32} {
+		for _, ipVersion := range []uint{4, 6} {
+			path := filepath.Join(t.TempDir(), "test.mmdb")
+			err := ioutil.WriteFile(path, buildMMDB(t, recordSize, ipVersion, networks), 0600)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = Open(path)
+			if err != nil {
+				t.Fatalf("%d bit records, IPv%d: Open: %v", recordSize, ipVersion, err)
+			}
+
+			for _, tt := range tests {
+				want := tt.country
+				if tt.ipv6Only && ipVersion == 4 {
+					want = ""
+				}
+
+				country, region := Lookup(net.ParseIP(tt.ip))
+				if country != want || region != tt.region {
+					t.Errorf("%d bit records, IPv%d: Lookup(%s) = %q, %q, want %q, %q",
+						recordSize, ipVersion, tt.ip, country, region, want, tt.region)
+				}
+			}
+		}
+	}
+}
+
+func TestParseMMDB(t *testing.T) {
+	valid := buildMMDB(t, 24, 4, map[string][]byte{"1.2.3.0/24": str("x")})
+
+	if _, err := parseMMDB(valid[:bytes.LastIndex(valid, metadataMarker)]); err != ErrNoMetadata {
+		t.Errorf("without metadata = %v, want %v", err, ErrNoMetadata)
+	}
+
+	withMeta := func(meta []byte) []byte {
+		buf := append([]byte(nil), valid[:bytes.LastIndex(valid, metadataMarker)]...)
+		return append(append(buf, metadataMarker...), meta...)
+	}
+
+	if _, err := parseMMDB(withMeta(str("x"))); err != ErrBadMetadata {
+		t.Errorf("metadata not a map = %v, want %v", err, ErrBadMetadata)
+	}
+
+	_, err := parseMMDB(withMeta(mapOf(
+		str("node_count"), uint32Value(1),
+		str("record_size"), uint16Value(16),
+		str("ip_version"), uint16Value(4),
+	)))
+	if err != ErrUnsupportedDB {
+		t.Errorf("16 bit records = %v, want %v", err, ErrUnsupportedDB)
+	}
+
+	_, err = parseMMDB(withMeta(mapOf(
+		str("node_count"), uint32Value(math.MaxUint16),
+		str("record_size"), uint16Value(24),
+		str("ip_version"), uint16Value(4),
+	)))
+	if err != ErrBadMetadata {
+		t.Errorf("tree larger than the file = %v, want %v", err, ErrBadMetadata)
+	}
+}
@@ -0,0 +1,215 @@
+package health
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	LivePath  = "/healthz"
+	ReadyPath = "/readyz"
+
+	checkTimeout = 2 * time.Second
+	probeTimeout = 5 * time.Second
+
+	// how long check results are reused for, so probes can't be used to load what the server depends on
+	resultsTTL = 5 * time.Second
+
+	statusOK           = "ok"
+	statusError        = "error"
+	statusUnavailable  = "unavailable"
+	statusShuttingDown = "shutting down"
+)
+
+// Check reports why something the server needs isn't working, or nil if it is.
+type Check func(ctx context.Context) error
+
+var (
+	mutex  sync.RWMutex
+	checks = make(map[string]Check)
+
+	started      = time.Now()
+	shuttingDown int32
+
+	resultsMutex sync.Mutex
+	results      map[string]checkResult
+	resultsAt    time.Time
+)
+
+// Register adds check, named name, to what readiness depends on.
+func Register(name string, check Check) {
+	mutex.Lock()
+	checks[name] = check
+	mutex.Unlock()
+}
+
+// ShuttingDown marks the server as no longer ready, ie: so load balancers stop sending it requests before it stops
+// accepting them.
+func ShuttingDown() {
+	atomic.StoreInt32(&shuttingDown, 1)
+}
+
+// Middleware serves LivePath and ReadyPath, passing everything else to next. It should wrap everything else, so
+// probes don't depend on, or get recorded by, the rest of the server.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case LivePath:
+			Live(w, r)
+		case ReadyPath:
+			Ready(w, r)
+		default:
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+type liveResponse struct {
+	Status string  `json:"status"`
+	Uptime float64 `json:"uptimeSeconds"`
+}
+
+// Live responds that the server is alive - if it can respond, it is.
+func Live(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, &liveResponse{
+		Status: statusOK,
+		Uptime: time.Since(started).Seconds(),
+	})
+}
+
+type readyResponse struct {
+	Status string                 `json:"status"`
+	Checks map[string]checkResult `json:"checks,omitempty"`
+}
+
+type checkResult struct {
+	Status   string  `json:"status"`
+	Error    string  `json:"error,omitempty"`
+	Duration float64 `json:"durationMs"`
+}
+
+// Ready responds whether every check passed, and which didn't, but not why, as it's served publicly. Checks are run at
+// most once every few seconds. It's never ready once ShuttingDown is called.
+func Ready(w http.ResponseWriter, r *http.Request) {
+	ready(w, false)
+}
+
+// ReadyDetail is Ready, but with why each failed check failed. It should only be served where the public can't reach
+// it, ie: the metrics listener.
+func ReadyDetail(w http.ResponseWriter, r *http.Request) {
+	ready(w, true)
+}
+
+func ready(w http.ResponseWriter, detail bool) {
+	if atomic.LoadInt32(&shuttingDown) == 1 {
+		writeJSON(w, http.StatusServiceUnavailable, &readyResponse{Status: statusShuttingDown})
+		return
+	}
+
+	resp := readyResponse{
+		Status: statusOK,
+		Checks: make(map[string]checkResult),
+	}
+	status := http.StatusOK
+	for name, result := range latestResults() {
+		if result.Status != statusOK {
+			resp.Status = statusUnavailable
+			status = http.StatusServiceUnavailable
+		}
+		if !detail {
+			result.Error = ""
+		}
+		resp.Checks[name] = result
+	}
+
+	writeJSON(w, status, &resp)
+}
+
+// latestResults returns the results of the checks, running them again if they're older than resultsTTL.
+// Concurrent callers wait for one run, rather than each running them.
+func latestResults() map[string]checkResult {
+	resultsMutex.Lock()
+	defer resultsMutex.Unlock()
+
+	if results == nil || time.Since(resultsAt) >= resultsTTL {
+		// not the request's context, as the results are shared with other requests
+		ctx, cancel := context.WithTimeout(context.Background(), checkTimeout)
+		results = runChecks(ctx)
+		resultsAt = time.Now()
+		cancel()
+	}
+	return results
+}
+
+// runChecks runs every check at once, so a slow one doesn't hold up the others
+func runChecks(ctx context.Context) map[string]checkResult {
+	mutex.RLock()
+	run := make(map[string]Check, len(checks))
+	for name, check := range checks {
+		run[name] = check
+	}
+	mutex.RUnlock()
+
+	var (
+		wait      sync.WaitGroup
+		resultsMu sync.Mutex
+		results   = make(map[string]checkResult, len(run))
+	)
+
+	wait.Add(len(run))
+	for name, check := range run {
+		go func(name string, check Check) {
+			defer wait.Done()
+
+			start := time.Now()
+			err := check(ctx)
+			result := checkResult{
+				Status:   statusOK,
+				Duration: float64(time.Since(start)) / float64(time.Millisecond),
+			}
+			if err != nil {
+				result.Status = statusError
+				result.Error = err.Error()
+			}
+
+			resultsMu.Lock()
+			results[name] = result
+			resultsMu.Unlock()
+		}(name, check)
+	}
+	wait.Wait()
+
+	return results
+}
+
+// Probe requests url (ie: a LivePath or ReadyPath), and returns why it isn't healthy (or ready), if it isn't.
+func Probe(url string) error {
+	client := http.Client{Timeout: probeTimeout}
+
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(body))
+	}
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,62 @@
+package httpx
+
+import (
+	"net/http"
+)
+
+// ResponseWriter remembers the status code and size of the response written through it
+type ResponseWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+// Wrap returns w as a *ResponseWriter, wrapping it only if it isn't one already, so middleware that each need the
+// status or size of the response can share a single wrapper.
+func Wrap(w http.ResponseWriter) *ResponseWriter {
+	if rw, ok := w.(*ResponseWriter); ok {
+		return rw
+	}
+	return &ResponseWriter{ResponseWriter: w}
+}
+
+func (w *ResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *ResponseWriter) Write(buf []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(buf)
+	w.bytes += int64(n)
+	return n, err
+}
+
+func (w *ResponseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Status returns the status code of the response, which is 200 if the handler never set one.
+func (w *ResponseWriter) Status() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+	return w.status
+}
+
+// Bytes returns the size of the response body written so far.
+func (w *ResponseWriter) Bytes() int64 {
+	return w.bytes
+}
+
+// Streaming reports whether the response is a stream of Server-Sent Events, which lasts as long as the client stays
+// connected, so its duration says nothing about how quickly it was served.
+func (w *ResponseWriter) Streaming() bool {
+	return w.Header().Get("Content-Type") == "text/event-stream"
+}
@@ -0,0 +1,25 @@
+package logme
+
+import "context"
+
+type fieldsCtxKey struct{}
+
+// NewContext returns a copy of ctx carrying the key/value pairs kv, after any it already carried, for Logger.Ctx to
+// add to records (ie: a request's ID, and user).
+func NewContext(ctx context.Context, kv ...interface{}) context.Context {
+	have, _ := ctx.Value(fieldsCtxKey{}).([]interface{})
+
+	fields := make([]interface{}, 0, len(have)+len(kv))
+	fields = append(fields, have...)
+	fields = append(fields, kv...)
+	return context.WithValue(ctx, fieldsCtxKey{}, fields)
+}
+
+// Ctx returns a Logger that adds the key/value pairs carried by ctx (see NewContext) to each of its records.
+func (l *Logger) Ctx(ctx context.Context) *Logger {
+	fields, _ := ctx.Value(fieldsCtxKey{}).([]interface{})
+	if len(fields) == 0 {
+		return l
+	}
+	return l.With(fields...)
+}
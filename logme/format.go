@@ -0,0 +1,126 @@
+package logme
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	formatText = "text"
+	formatJSON = "json"
+
+	timeLayout = "2006-01-02T15:04:05.000Z07:00"
+
+	// the key of a value without one
+	badKey = "!BADKEY"
+)
+
+type record struct {
+	time   time.Time
+	level  Level
+	pkg    string
+	msg    string
+	fields []interface{}
+}
+
+// each calls fn with each key/value pair of r's fields
+func (r *record) each(fn func(key string, value interface{})) {
+	for i := 0; i < len(r.fields); i += 2 {
+		if i+1 == len(r.fields) {
+			fn(badKey, r.fields[i])
+			break
+		}
+
+		key, ok := r.fields[i].(string)
+		if !ok {
+			key = fmt.Sprint(r.fields[i])
+		}
+		fn(key, r.fields[i+1])
+	}
+}
+
+// ie: 2006-01-02T15:04:05.000Z INFO  [visitors] message key=value other="quoted value"
+func (r *record) writeText(buf *bytes.Buffer) {
+	buf.WriteString(r.time.Format(timeLayout))
+	buf.WriteByte(' ')
+	fmt.Fprintf(buf, "%-5s", strings.ToUpper(r.level.String()))
+	if r.pkg != "" {
+		buf.WriteString(" [")
+		buf.WriteString(r.pkg)
+		buf.WriteByte(']')
+	}
+	buf.WriteByte(' ')
+	buf.WriteString(r.msg)
+
+	r.each(func(key string, value interface{}) {
+		buf.WriteByte(' ')
+		buf.WriteString(key)
+		buf.WriteByte('=')
+		buf.WriteString(textValue(value))
+	})
+	buf.WriteByte('\n')
+}
+
+func textValue(value interface{}) string {
+	var s string
+	switch v := value.(type) {
+	case string:
+		s = v
+	case error:
+		s = v.Error()
+	case time.Time:
+		s = v.Format(timeLayout)
+	case time.Duration:
+		s = v.String()
+	case nil:
+		s = "<nil>"
+	default:
+		s = fmt.Sprint(v)
+	}
+
+	if s == "" || strings.ContainsAny(s, " =\"\t\n") {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+// ie: {"time":"2006-01-02T15:04:05.000Z","level":"info","pkg":"visitors","msg":"message","key":"value"}
+func (r *record) writeJSON(buf *bytes.Buffer) {
+	buf.WriteString(`{"time":`)
+	writeJSONValue(buf, r.time.Format(timeLayout))
+	buf.WriteString(`,"level":`)
+	writeJSONValue(buf, r.level.String())
+	if r.pkg != "" {
+		buf.WriteString(`,"pkg":`)
+		writeJSONValue(buf, r.pkg)
+	}
+	buf.WriteString(`,"msg":`)
+	writeJSONValue(buf, r.msg)
+
+	r.each(func(key string, value interface{}) {
+		buf.WriteByte(',')
+		writeJSONValue(buf, key)
+		buf.WriteByte(':')
+
+		switch v := value.(type) {
+		case error:
+			value = v.Error()
+		case time.Duration:
+			value = v.String()
+		}
+		writeJSONValue(buf, value)
+	})
+	buf.WriteString("}\n")
+}
+
+func writeJSONValue(buf *bytes.Buffer, value interface{}) {
+	enc, err := json.Marshal(value)
+	if err != nil {
+		enc, _ = json.Marshal(fmt.Sprint(value))
+	}
+	buf.Write(enc)
+}
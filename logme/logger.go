@@ -0,0 +1,200 @@
+package logme
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var (
+	ErrUnknownLevel  = errors.New("unknown log level")
+	ErrUnknownFormat = errors.New("unknown log format")
+)
+
+var levelNames = [...]string{
+	LevelDebug: "debug",
+	LevelInfo:  "info",
+	LevelWarn:  "warn",
+	LevelError: "error",
+}
+
+func (l Level) String() string {
+	if l < LevelDebug || l > LevelError {
+		return fmt.Sprintf("level(%d)", int(l))
+	}
+	return levelNames[l]
+}
+
+// ParseLevel parses "debug", "info", "warn" (or "warning"), or "error".
+func ParseLevel(s string) (Level, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "debug":
+		return LevelDebug, nil
+	case "info":
+		return LevelInfo, nil
+	case "warn", "warning":
+		return LevelWarn, nil
+	case "error":
+		return LevelError, nil
+	default:
+		return 0, fmt.Errorf("%v: %q", ErrUnknownLevel, s)
+	}
+}
+
+var (
+	settingsMutex sync.RWMutex
+	format        = formatText
+	defaultLevel  = LevelInfo
+	pkgLevels     = map[string]Level{}
+)
+
+// SetFormat sets how records are written: "text", for people, or "json", one object per line, for log shippers.
+func SetFormat(f string) error {
+	switch f {
+	case formatText, formatJSON:
+	default:
+		return fmt.Errorf("%v: %q", ErrUnknownFormat, f)
+	}
+
+	settingsMutex.Lock()
+	format = f
+	settingsMutex.Unlock()
+	return nil
+}
+
+// SetLevel sets the minimum level of records written, for packages without their own (see SetLevels).
+func SetLevel(level Level) {
+	settingsMutex.Lock()
+	defaultLevel = level
+	settingsMutex.Unlock()
+}
+
+// SetLevels overrides the minimum level of packages' records, from comma separated "package=level" pairs
+// (ie: "db=debug,visitors=warn"). Packages not listed use the level set by SetLevel.
+func SetLevels(spec string) error {
+	levels := map[string]Level{}
+	for _, pair := range strings.Split(spec, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("log level %q is not package=level", pair)
+		}
+
+		level, err := ParseLevel(parts[1])
+		if err != nil {
+			return err
+		}
+		levels[strings.TrimSpace(parts[0])] = level
+	}
+
+	settingsMutex.Lock()
+	pkgLevels = levels
+	settingsMutex.Unlock()
+	return nil
+}
+
+// Logger writes structured records: a message, and key/value pairs describing it.
+type Logger struct {
+	pkg    string
+	fields []interface{}
+}
+
+// For returns a Logger for pkg, so its level can be set separately (see SetLevels).
+func For(pkg string) *Logger {
+	return &Logger{pkg: pkg}
+}
+
+// With returns a Logger that adds the key/value pairs kv to each of its records.
+func (l *Logger) With(kv ...interface{}) *Logger {
+	fields := make([]interface{}, 0, len(l.fields)+len(kv))
+	fields = append(fields, l.fields...)
+	fields = append(fields, kv...)
+	return &Logger{pkg: l.pkg, fields: fields}
+}
+
+// Enabled reports whether records of level would be written.
+func (l *Logger) Enabled(level Level) bool {
+	settingsMutex.RLock()
+	defer settingsMutex.RUnlock()
+
+	min, ok := pkgLevels[l.pkg]
+	if !ok {
+		min = defaultLevel
+	}
+	return level >= min
+}
+
+func (l *Logger) Debug(msg string, kv ...interface{}) {
+	l.Log(LevelDebug, msg, kv...)
+}
+
+func (l *Logger) Info(msg string, kv ...interface{}) {
+	l.Log(LevelInfo, msg, kv...)
+}
+
+func (l *Logger) Warn(msg string, kv ...interface{}) {
+	l.Log(LevelWarn, msg, kv...)
+}
+
+func (l *Logger) Error(msg string, kv ...interface{}) {
+	l.Log(LevelError, msg, kv...)
+}
+
+// Log writes a record of msg, and the alternating keys and values of kv (ie: "user", name, "err", err).
+func (l *Logger) Log(level Level, msg string, kv ...interface{}) {
+	if !l.Enabled(level) {
+		return
+	}
+
+	settingsMutex.RLock()
+	f := format
+	settingsMutex.RUnlock()
+
+	r := record{
+		time:   time.Now(),
+		level:  level,
+		pkg:    l.pkg,
+		msg:    msg,
+		fields: append(l.fields[:len(l.fields):len(l.fields)], kv...),
+	}
+
+	var buf bytes.Buffer
+	if f == formatJSON {
+		r.writeJSON(&buf)
+	} else {
+		r.writeText(&buf)
+	}
+	write(level, buf.Bytes())
+}
+
+// Std returns a *log.Logger that writes each message as a record of level, ie: for http.Server.ErrorLog.
+func (l *Logger) Std(level Level) *log.Logger {
+	return log.New(&stdWriter{logger: l, level: level}, "", 0)
+}
+
+type stdWriter struct {
+	logger *Logger
+	level  Level
+}
+
+func (w *stdWriter) Write(buf []byte) (int, error) {
+	w.logger.Log(w.level, strings.TrimSuffix(string(buf), "\n"))
+	return len(buf), nil
+}
@@ -4,8 +4,7 @@ import (
 	"io"
 	"log"
 	"os"
-	"path"
-	"time"
+	"sync"
 )
 
 var (
@@ -13,28 +12,36 @@ var (
 	warn *log.Logger
 	errs *log.Logger
 
-	logFile *os.File
+	logFile *File
+
+	// everything is written to out, and warnings and errors to stderr too
+	outMutex sync.Mutex
+	out      io.Writer = os.Stderr
+	errOut   io.Writer
 )
 
-func Init(logsDir string) error {
-	err := os.MkdirAll(logsDir, os.ModeDir|0755)
+// Init starts writing logs to server.log in dir, rotated as set by SetRotation.
+func Init(dir string) error {
+	err := os.MkdirAll(dir, os.ModeDir|0755)
 	if err != nil {
 		return err
 	}
+	logsDir = dir
 
-	logFileNameFormat := time.Now().Format("2006-01-02 15_04_05")
-
-	logFile, err = os.OpenFile(path.Join(logsDir, logFileNameFormat+".txt"), os.O_CREATE|os.O_RDWR, 0644)
+	logFile, err = OpenFile("server")
 	if err != nil {
 		return err
 	}
 
-	info = log.New(logFile, "[INFO] ", log.LstdFlags)
-	warn = log.New(io.MultiWriter(logFile, os.Stderr), "[WARN] ", log.LstdFlags)
-	errs = log.New(io.MultiWriter(logFile, os.Stderr), "[ERROR] ", log.LstdFlags|log.Lshortfile)
+	outMutex.Lock()
+	out = logFile
+	errOut = os.Stderr
+	outMutex.Unlock()
 	return nil
 }
 
+// Info, Warn, and Err log unstructured messages, for code that hasn't moved to For's Loggers yet, and things that need
+// a *log.Logger.
 func Info() *log.Logger {
 	return info
 }
@@ -48,8 +55,28 @@ func Err() *log.Logger {
 }
 
 func Deinit() {
-	if logFile != nil {
-		logFile.Sync()
-		logFile.Close()
+	outMutex.Lock()
+	defer outMutex.Unlock()
+
+	closeFiles()
+	logFile = nil
+	out = os.Stderr
+	errOut = nil
+}
+
+func init() {
+	root := For("")
+	info = root.Std(LevelInfo)
+	warn = root.Std(LevelWarn)
+	errs = log.New(&stdWriter{logger: root, level: LevelError}, "", log.Lshortfile)
+}
+
+func write(level Level, buf []byte) {
+	outMutex.Lock()
+	defer outMutex.Unlock()
+
+	out.Write(buf)
+	if level >= LevelWarn && errOut != nil {
+		errOut.Write(buf)
 	}
 }
@@ -0,0 +1,295 @@
+package logme
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	logExt = ".log"
+
+	// sorts in time order, and is safe in file names everywhere
+	rotatedLayout = "20060102T150405.000"
+)
+
+// Rotation is when log files are rotated, and how many of the rotated ones are kept.
+type Rotation struct {
+	MaxSize  int64         // bytes, or 0 for no limit
+	Interval time.Duration // ie: 24 hours to rotate at midnight UTC, or 0 for never
+	MaxAge   time.Duration // of rotated files, or 0 to keep them forever
+	MaxCount int           // of rotated files, or 0 to keep all of them
+	Compress bool          // gzip rotated files
+}
+
+var (
+	rotationMutex sync.RWMutex
+	rotation      Rotation
+	logsDir       = "."
+
+	filesMutex sync.Mutex
+	files      []*File
+)
+
+// SetRotation sets the rotation policy of every log file.
+func SetRotation(r Rotation) {
+	rotationMutex.Lock()
+	rotation = r
+	rotationMutex.Unlock()
+}
+
+func getRotation() Rotation {
+	rotationMutex.RLock()
+	defer rotationMutex.RUnlock()
+	return rotation
+}
+
+// File is a log file that rotates itself, as set by SetRotation. It's written to as name.log, in the directory passed
+// to Init, and rotated to name-<time>.log.
+type File struct {
+	mutex  sync.Mutex
+	name   string
+	path   string
+	file   *os.File
+	size   int64
+	opened time.Time
+
+	// rotations can outpace cleaning up after them, which mustn't prune a file while it's still being compressed
+	cleanupMutex sync.Mutex
+}
+
+// OpenFile opens (or creates) the log file name. It's reopened by Reopen, and closed by Deinit.
+func OpenFile(name string) (*File, error) {
+	f := &File{
+		name: name,
+		path: filepath.Join(logsDir, name+logExt),
+	}
+
+	err := f.open()
+	if err != nil {
+		return nil, err
+	}
+
+	filesMutex.Lock()
+	files = append(files, f)
+	filesMutex.Unlock()
+	return f, nil
+}
+
+// Reopen closes and reopens every log file, ie: after an external rotator (like logrotate) moved them.
+func Reopen() error {
+	filesMutex.Lock()
+	defer filesMutex.Unlock()
+
+	var first error
+	for _, f := range files {
+		err := f.Reopen()
+		if err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+func closeFiles() {
+	filesMutex.Lock()
+	defer filesMutex.Unlock()
+
+	for _, f := range files {
+		f.Close()
+	}
+	files = nil
+}
+
+func (f *File) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	f.file = file
+	f.size = info.Size()
+	f.opened = info.ModTime()
+	if f.size == 0 {
+		f.opened = time.Now()
+	}
+	return nil
+}
+
+func (f *File) Write(buf []byte) (n int, err error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.file == nil {
+		return 0, os.ErrClosed
+	}
+
+	if f.shouldRotate(int64(len(buf)), time.Now()) {
+		err = f.rotate()
+		if err != nil {
+			// keep logging to the old file, rather than losing logs
+			f.opened = time.Now()
+		}
+	}
+
+	n, err = f.file.Write(buf)
+	f.size += int64(n)
+	return
+}
+
+func (f *File) shouldRotate(size int64, now time.Time) bool {
+	policy := getRotation()
+
+	if policy.MaxSize > 0 && f.size > 0 && f.size+size > policy.MaxSize {
+		return true
+	}
+	if policy.Interval > 0 && !now.UTC().Truncate(policy.Interval).Equal(f.opened.UTC().Truncate(policy.Interval)) {
+		return true
+	}
+	return false
+}
+
+// Rotate moves the file aside, and starts a new one.
+func (f *File) Rotate() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.file == nil {
+		return os.ErrClosed
+	}
+	return f.rotate()
+}
+
+func (f *File) rotate() error {
+	rotated := filepath.Join(logsDir, f.name+"-"+time.Now().UTC().Format(rotatedLayout)+logExt)
+
+	err := os.Rename(f.path, rotated)
+	if err != nil {
+		return err
+	}
+
+	file := f.file
+	err = f.open()
+	if err != nil {
+		// keep logging to the old file, put back where it was, so the next rotation tries again
+		os.Rename(rotated, f.path)
+		return err
+	}
+	file.Close()
+
+	go f.cleanup(rotated, getRotation())
+	return nil
+}
+
+// Reopen closes and reopens the file, at its original path. If it can't be, the old file is kept.
+func (f *File) Reopen() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	file := f.file
+	err := f.open()
+	if err != nil {
+		return err
+	}
+
+	if file != nil {
+		file.Close()
+	}
+	return nil
+}
+
+func (f *File) Close() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.file == nil {
+		return nil
+	}
+
+	f.file.Sync()
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
+
+// cleanup compresses the just rotated file, and removes rotated files the policy says not to keep, one rotation at a
+// time. It reports problems to stderr, as logging them could rotate again.
+func (f *File) cleanup(rotated string, policy Rotation) {
+	f.cleanupMutex.Lock()
+	defer f.cleanupMutex.Unlock()
+
+	if policy.Compress {
+		err := compress(rotated)
+		if err != nil {
+			os.Stderr.WriteString("compressing rotated log " + rotated + ": " + err.Error() + "\n")
+		}
+	}
+
+	if policy.MaxAge <= 0 && policy.MaxCount <= 0 {
+		return
+	}
+
+	old, err := filepath.Glob(filepath.Join(logsDir, f.name+"-*"+logExt+"*"))
+	if err != nil {
+		return
+	}
+
+	// newest first
+	sort.Sort(sort.Reverse(sort.StringSlice(old)))
+
+	cutoff := time.Now().Add(-policy.MaxAge)
+	for i, path := range old {
+		remove := policy.MaxCount > 0 && i >= policy.MaxCount
+		if !remove && policy.MaxAge > 0 {
+			info, err := os.Stat(path)
+			remove = err == nil && info.ModTime().Before(cutoff)
+		}
+
+		if remove {
+			os.Remove(path)
+		}
+	}
+}
+
+func compress(path string) (err error) {
+	if strings.HasSuffix(path, ".gz") {
+		return
+	}
+
+	in, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(path+".gz", os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return
+	}
+
+	zip := gzip.NewWriter(out)
+	_, err = io.Copy(zip, in)
+	if err == nil {
+		err = zip.Close()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		os.Remove(path + ".gz")
+		return
+	}
+	return os.Remove(path)
+}
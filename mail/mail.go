@@ -2,13 +2,19 @@ package mail
 
 import (
 	"bytes"
+	"context"
     "errors"
 	htmlTemplate "html/template"
 	"io"
+	"net"
 	"net/smtp"
 	"regexp"
 	"sync"
+	"sync/atomic"
 	textTemplate "text/template"
+	"time"
+
+	"github.com/dabbertorres/web-srv-base/trace"
 )
 
 const (
@@ -39,8 +45,24 @@ var (
 	headers        = textTemplate.Must(textTemplate.New("headers").Parse("From: {{ .From }}\nTo: {{ .To }}\nSubject: {{ .Subject }}\n\n"))
 
 	newlineCorrecter = regexp.MustCompile("\\r?\\n")
+
+	sent   uint64
+	failed uint64
 )
 
+// Stats counts the mail sent, and that failed to send.
+type Stats struct {
+	Sent   uint64
+	Failed uint64
+}
+
+func GetStats() Stats {
+	return Stats{
+		Sent:   atomic.LoadUint64(&sent),
+		Failed: atomic.LoadUint64(&failed),
+	}
+}
+
 func From(from string) {
 	serverFromAddr = from
 }
@@ -75,7 +97,40 @@ func LoadTemplateHTML(name, subject, text string) error {
 	return nil
 }
 
-func Send(templateName, to string, data interface{}) (err error) {
+// Ping checks the mail server is accepting connections, by connecting and saying goodbye.
+func Ping(ctx context.Context) (err error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", serverAddr)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	host, _, _ := net.SplitHostPort(serverAddr)
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		return
+	}
+	return client.Quit()
+}
+
+// Send sends the mail of templateName, executed with data, to to. It's traced as a child of the span in ctx.
+func Send(ctx context.Context, templateName, to string, data interface{}) (err error) {
+	start := time.Now()
+	defer func() {
+		trace.Record(ctx, "mail send", trace.KindClient, start, err, "mail.template", templateName)
+
+		if err != nil {
+			atomic.AddUint64(&failed, 1)
+		} else {
+			atomic.AddUint64(&sent, 1)
+		}
+	}()
+
 	buf := bytes.NewBuffer(nil)
 
 	mutex.RLock()
@@ -3,29 +3,56 @@ package main
 import (
 	"context"
 	"crypto/tls"
+	"fmt"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
+	_ "time/tzdata" // the image has no zoneinfo, for "tz" parameters
 
 	"golang.org/x/crypto/acme/autocert"
 
 	"github.com/gorilla/mux"
 
 	"github.com/dabbertorres/how"
+	"github.com/dabbertorres/web-srv-base/accesslog"
 	"github.com/dabbertorres/web-srv-base/db"
 	"github.com/dabbertorres/web-srv-base/dialogue"
+	"github.com/dabbertorres/web-srv-base/geoip"
+	"github.com/dabbertorres/web-srv-base/health"
 	"github.com/dabbertorres/web-srv-base/logme"
+	"github.com/dabbertorres/web-srv-base/metrics"
+	"github.com/dabbertorres/web-srv-base/realip"
 	"github.com/dabbertorres/web-srv-base/tmpl"
+	"github.com/dabbertorres/web-srv-base/trace"
+	"github.com/dabbertorres/web-srv-base/visitors"
 )
 
+const (
+	healthcheckArg = "healthcheck"
+	healthcheckURL = "http://localhost" + health.LivePath
+)
+
+var logger = logme.For("main")
+
 func main() {
+	// for the container's healthcheck - the image has nothing else to make requests with
+	if len(os.Args) == 2 && os.Args[1] == healthcheckArg {
+		err := health.Probe(healthcheckURL)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
 	exitCode := 0
 	defer os.Exit(exitCode)
 
-	interrupt := make(chan os.Signal)
+	interrupt := make(chan os.Signal, 1)
 	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM, syscall.SIGKILL)
 
 	err := logme.Init("logs")
@@ -40,17 +67,43 @@ func main() {
 	cfg, err := LoadConfig()
 	if err != nil {
 		if err != how.ErrShowHelp {
-			logme.Err().Println("Loading config:", err)
+			logger.Error("Loading config", "err", err)
 		}
 		exitCode = 1
 		return
 	}
 
+	err = LogSetup(&cfg)
+	if err != nil {
+		logger.Error("Configuring logging", "err", err)
+		exitCode = 1
+		return
+	}
+
+	// for external log rotators
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	go func() {
+		for range hangup {
+			err := logme.Reopen()
+			if err != nil {
+				logger.Error("Reopening log files", "err", err)
+			}
+		}
+	}()
+
+	err = TraceSetup(&cfg)
+	if err != nil {
+		logger.Error("Starting tracing", "err", err)
+		exitCode = 1
+		return
+	}
+
 	// state setup...
 
 	err = dialogue.Open(time.Duration(cfg.SessionTTL) * time.Second)
 	if err != nil {
-		logme.Err().Println("Opening sessions file:", err)
+		logger.Error("Opening sessions file", "err", err)
 		exitCode = 1
 		return
 	}
@@ -58,34 +111,100 @@ func main() {
 
 	err = db.Open(cfg.DBAddr, cfg.DBDriver)
 	if err != nil {
-		logme.Err().Println("Connecting to DB:", err)
+		logger.Error("Connecting to DB", "err", err)
 		exitCode = 1
 		return
 	}
 	defer db.Close()
 
+	migrateCtx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	err = db.Migrate(migrateCtx, migrationsDir)
+	cancel()
+	if err != nil {
+		logger.Error("Migrating DB", "err", err)
+		exitCode = 1
+		return
+	}
+
+	if cfg.OIDCIssuer != "" {
+		oidcCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		err = OIDCSetup(oidcCtx, &cfg)
+		cancel()
+		if err != nil {
+			logger.Error("Configuring OIDC provider", "err", err)
+			exitCode = 1
+			return
+		}
+	}
+
+	if cfg.TrustedProxies != "" {
+		err = realip.SetTrustedProxies(strings.Split(cfg.TrustedProxies, ","))
+		if err != nil {
+			logger.Error("Parsing trusted proxies", "err", err)
+			exitCode = 1
+			return
+		}
+
+		err = realip.SetProxyHeader(cfg.ProxyHeader)
+		if err != nil {
+			logger.Error("Setting trusted proxy header", "header", cfg.ProxyHeader, "err", err)
+			exitCode = 1
+			return
+		}
+	}
+
+	if cfg.GeoIPDB != "" {
+		err = GeoIPSetup(&cfg)
+		if err != nil {
+			logger.Error("Loading GeoIP database", "err", err)
+			exitCode = 1
+			return
+		}
+		defer geoip.Close()
+	}
+
+	err = VisitorsSetup(&cfg)
+	if err != nil {
+		logger.Error("Starting visit recording", "err", err)
+		exitCode = 1
+		return
+	}
+
 	httpsMan := LetsEncryptSetup(&cfg)
 
 	// web interface...
 
 	err = tmpl.Load("app")
 	if err != nil {
-		logme.Err().Println("Loading templates and pages:", err)
+		logger.Error("Loading templates and pages", "err", err)
 		exitCode = 1
 		return
 	}
 
 	// run...
 
+	HealthSetup()
+	MetricsSetup()
+
 	var (
 		// handle ACME requests, otherwise redirect all other traffic to the https version
 		insecureSrv = startInsecure(httpsMan)
 		srv         = startSecure(httpsMan, &cfg)
 	)
 
+	if cfg.MetricsAddr != "" {
+		metricsSrv := startMetrics(cfg.MetricsAddr)
+		defer metricsSrv.Close()
+	}
+
 	// try to shutdown gracefully when signaled...
 
 	<-interrupt
+
+	// give load balancers time to notice, and stop sending requests
+	health.ShuttingDown()
+	time.Sleep(time.Duration(cfg.ShutdownDrain) * time.Second)
+
 	cancelCtx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
 	defer cancel()
 
@@ -94,7 +213,7 @@ func main() {
 	// wait for shutdown or timeout
 	select {
 	case <-cancelCtx.Done():
-		logme.Err().Println("Shutdown timeout")
+		logger.Error("Shutdown timeout")
 		exitCode = 1
 
 	case <-done:
@@ -105,18 +224,18 @@ func main() {
 func startInsecure(man *autocert.Manager) (srv *http.Server) {
 	srv = &http.Server{
 		Addr: ":http",
-		Handler: man.HTTPHandler(http.HandlerFunc(
+		Handler: health.Middleware(accesslog.Middleware(man.HTTPHandler(http.HandlerFunc(
 			func(w http.ResponseWriter, r *http.Request) {
 				http.Redirect(w, r, "https://"+r.Host+r.RequestURI, http.StatusMovedPermanently)
-			})),
-		ErrorLog: logme.Err(),
+			})))),
+		ErrorLog: logme.For("http").Std(logme.LevelError),
 	}
 
 	// serve http for TLS SNI challenges and redirection to https
 	go func() {
 		err := srv.ListenAndServe()
 		if err != nil && err != http.ErrServerClosed {
-			logme.Err().Println("Server (http) ListenAndServe():", err)
+			logger.Error("Server (http) ListenAndServe()", "err", err)
 		}
 	}()
 	return
@@ -128,15 +247,18 @@ func startSecure(man *autocert.Manager, cfg *Config) (srv *http.Server) {
 
 	srv = &http.Server{
 		Addr:      ":https",
-		Handler:   router,
-		ErrorLog:  logme.Err(),
+		Handler:   health.Middleware(accesslog.Middleware(metrics.Middleware(router))),
+		ErrorLog:  logme.For("http").Std(logme.LevelError),
 		TLSConfig: &tls.Config{GetCertificate: man.GetCertificate},
 	}
 
+	// live feeds would otherwise hold up shutting down until the timeout
+	srv.RegisterOnShutdown(visitors.CloseSubscriptions)
+
 	go func() {
 		err := srv.ListenAndServeTLS("", "")
 		if err != nil && err != http.ErrServerClosed {
-			logme.Err().Println("Server (https) ListenAndServeTLS():", err)
+			logger.Error("Server (https) ListenAndServeTLS()", "err", err)
 		}
 	}()
 	return
@@ -149,7 +271,7 @@ func shutdown(ctx context.Context, insecure, secure *http.Server) (<-chan struct
 	go func() {
 		err := insecure.Shutdown(ctx)
 		if err != nil {
-			logme.Err().Println("Server (insecure) Shutdown():", err)
+			logger.Error("Server (insecure) Shutdown()", "err", err)
 		}
 		wait.Done()
 	}()
@@ -157,7 +279,7 @@ func shutdown(ctx context.Context, insecure, secure *http.Server) (<-chan struct
 	go func() {
 		err := secure.Shutdown(ctx)
 		if err != nil {
-			logme.Err().Println("Server Shutdown():", err)
+			logger.Error("Server Shutdown()", "err", err)
 		}
 		wait.Done()
 	}()
@@ -165,6 +287,20 @@ func shutdown(ctx context.Context, insecure, secure *http.Server) (<-chan struct
 	done := make(chan struct{})
 	go func() {
 		wait.Wait()
+
+		// no more requests, so no more visits - record what's left
+		err := visitors.Stop(ctx)
+		if err != nil {
+			logger.Error("Stopping visit recording", "err", err)
+		}
+
+		if trace.Enabled() {
+			err = trace.Close(ctx)
+			if err != nil {
+				logger.Error("Stopping tracing", "err", err)
+			}
+		}
+
 		close(done)
 	}()
 	return done
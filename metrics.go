@@ -0,0 +1,133 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/dabbertorres/web-srv-base/db"
+	"github.com/dabbertorres/web-srv-base/dialogue"
+	"github.com/dabbertorres/web-srv-base/health"
+	"github.com/dabbertorres/web-srv-base/logme"
+	"github.com/dabbertorres/web-srv-base/mail"
+	"github.com/dabbertorres/web-srv-base/metrics"
+	"github.com/dabbertorres/web-srv-base/visitors"
+)
+
+// counting sessions reads through all of them, so is done at most this often, rather than for every metric of them
+const sessionStatsMaxAge = 5 * time.Second
+
+// MetricsSetup exposes the state of the server's parts as metrics.
+func MetricsSetup() {
+	metrics.NewGaugeFunc("db_connections_open", "Open connections to the db, in use or idle.", func() float64 {
+		return float64(db.Stats().OpenConnections)
+	})
+	metrics.NewGaugeFunc("db_connections_in_use", "Connections to the db in use.", func() float64 {
+		return float64(db.Stats().InUse)
+	})
+	metrics.NewGaugeFunc("db_connections_idle", "Idle connections to the db.", func() float64 {
+		return float64(db.Stats().Idle)
+	})
+	metrics.NewCounterFunc("db_connections_waited_total", "Times a connection to the db had to be waited for.", func() float64 {
+		return float64(db.Stats().WaitCount)
+	})
+	metrics.NewCounterFunc("db_connections_wait_seconds_total", "Time spent waiting for connections to the db.", func() float64 {
+		return db.Stats().WaitDuration.Seconds()
+	})
+
+	sessions := sessionStats()
+	metrics.NewGaugeFunc("sessions", "Unexpired sessions.", func() float64 {
+		return float64(sessions().Sessions)
+	})
+	metrics.NewGaugeFunc("sessions_logged_in", "Unexpired sessions with a logged in user.", func() float64 {
+		return float64(sessions().LoggedIn)
+	})
+	metrics.NewGaugeFunc("sessions_expired", "Expired sessions not yet removed.", func() float64 {
+		return float64(sessions().Expired)
+	})
+	metrics.NewGaugeFunc("sessions_store_bytes", "Size of the session store.", func() float64 {
+		return float64(sessions().Bytes)
+	})
+	metrics.NewGaugeFunc("sessions_store_open_tables", "Tables the session store has open.", func() float64 {
+		return float64(sessions().Tables)
+	})
+	metrics.NewCounterFunc("sessions_store_read_bytes_total", "Bytes the session store has read.", func() float64 {
+		return float64(sessions().IORead)
+	})
+	metrics.NewCounterFunc("sessions_store_written_bytes_total", "Bytes the session store has written.", func() float64 {
+		return float64(sessions().IOWrite)
+	})
+	metrics.NewCounterFunc("sessions_store_write_delays_total", "Writes the session store delayed, while compacting.", func() float64 {
+		return float64(sessions().Delays)
+	})
+	metrics.NewCounterFunc("sessions_store_write_delay_seconds_total", "Time the session store delayed writes for.", func() float64 {
+		return sessions().DelayTime.Seconds()
+	})
+
+	metrics.NewCounterFunc("mail_sent_total", "Mail sent.", func() float64 {
+		return float64(mail.GetStats().Sent)
+	})
+	metrics.NewCounterFunc("mail_failed_total", "Mail that failed to send.", func() float64 {
+		return float64(mail.GetStats().Failed)
+	})
+
+	metrics.NewGaugeFunc("visits_queued", "Visits waiting to be recorded to the db.", func() float64 {
+		return float64(visitors.GetStats().Queued)
+	})
+	metrics.NewCounterFunc("visits_recorded_total", "Visits recorded to the db.", func() float64 {
+		return float64(visitors.GetStats().Recorded)
+	})
+	metrics.NewCounterFunc("visits_dropped_total", "Visits dropped, because the queue was full.", func() float64 {
+		return float64(visitors.GetStats().Dropped)
+	})
+	metrics.NewCounterFunc("visits_failed_total", "Visits that failed to be written to the db.", func() float64 {
+		return float64(visitors.GetStats().Failed)
+	})
+}
+
+// sessionStats returns a func returning dialogue's stats, as of at most sessionStatsMaxAge ago.
+func sessionStats() func() dialogue.Stats {
+	var (
+		mutex sync.Mutex
+		stats dialogue.Stats
+		read  time.Time
+	)
+
+	return func() dialogue.Stats {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		if time.Since(read) > sessionStatsMaxAge {
+			latest, err := dialogue.GetStats()
+			if err != nil {
+				logger.Warn("Reading session stats", "err", err)
+			} else {
+				stats = latest
+			}
+			read = time.Now()
+		}
+		return stats
+	}
+}
+
+// startMetrics serves metrics on addr, which should only be reachable by whatever collects them (ie: Prometheus).
+// Readiness is served there too, with why any checks failed, which the public listeners don't say.
+func startMetrics(addr string) (srv *http.Server) {
+	handler := http.NewServeMux()
+	handler.HandleFunc("/metrics", metrics.Handler)
+	handler.HandleFunc(health.ReadyPath, health.ReadyDetail)
+
+	srv = &http.Server{
+		Addr:     addr,
+		Handler:  handler,
+		ErrorLog: logme.For("http").Std(logme.LevelError),
+	}
+
+	go func() {
+		err := srv.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			logger.Error("Server (metrics) ListenAndServe()", "err", err)
+		}
+	}()
+	return
+}
@@ -0,0 +1,75 @@
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"runtime"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/dabbertorres/web-srv-base/httpx"
+)
+
+// the route of requests that didn't match one, ie: 404s
+const unmatched = "unmatched"
+
+type routeKey struct{}
+
+var (
+	requests = NewCounter("http_requests_total",
+		"Requests served, by route, method, and status code.",
+		"route", "method", "code")
+
+	latency = NewHistogram("http_request_duration_seconds",
+		"How long requests took to serve, by route and method.",
+		DefBuckets, "route", "method")
+)
+
+func init() {
+	NewGaugeFunc("go_goroutines", "Goroutines that currently exist.", func() float64 {
+		return float64(runtime.NumGoroutine())
+	})
+
+	NewGaugeFunc("go_memstats_heap_alloc_bytes", "Bytes allocated on the heap, and still in use.", func() float64 {
+		var stats runtime.MemStats
+		runtime.ReadMemStats(&stats)
+		return float64(stats.HeapAlloc)
+	})
+}
+
+// Middleware counts requests, and how long they take, by their route's path template - not their path, which would
+// be a new series for every user, token, etc. Streams are counted, but left out of the latencies.
+// It should wrap the whole router, with Route installed on it, so requests that don't match a route are counted too,
+// as the route "unmatched".
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := unmatched
+
+		start := time.Now()
+		rw := httpx.Wrap(w)
+		next.ServeHTTP(rw, r.WithContext(context.WithValue(r.Context(), routeKey{}, &route)))
+
+		requests.Inc(route, r.Method, strconv.Itoa(rw.Status()))
+		if !rw.Streaming() {
+			latency.Observe(time.Since(start).Seconds(), route, r.Method)
+		}
+	})
+}
+
+// Route tells Middleware which route a request matched. It must be installed on the router, for the route to be known.
+func Route(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if route, ok := r.Context().Value(routeKey{}).(*string); ok {
+			*route = "unknown"
+			if current := mux.CurrentRoute(r); current != nil {
+				if tmpl, err := current.GetPathTemplate(); err == nil {
+					*route = tmpl
+				}
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,293 @@
+package metrics
+
+import (
+	"bytes"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const contentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// DefBuckets are the upper bounds of Histogram buckets suited to request latencies, in seconds.
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type metric interface {
+	write(buf *bytes.Buffer)
+}
+
+var (
+	mutex   sync.RWMutex
+	metrics = make(map[string]metric)
+)
+
+// register panics if name is already registered, as that's a programming error, like http.Handle does.
+func register(name string, m metric) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if _, ok := metrics[name]; ok {
+		panic("metrics: " + name + " registered twice")
+	}
+	metrics[name] = m
+}
+
+// Handler serves every metric in Prometheus' text exposition format.
+func Handler(w http.ResponseWriter, r *http.Request) {
+	mutex.RLock()
+	names := make([]string, 0, len(metrics))
+	for name := range metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	for _, name := range names {
+		metrics[name].write(&buf)
+	}
+	mutex.RUnlock()
+
+	w.Header().Set("Content-Type", contentType)
+	w.Write(buf.Bytes())
+}
+
+// Counter is a value that only goes up, for each combination of its labels' values.
+type Counter struct {
+	name   string
+	help   string
+	labels []string
+
+	mutex  sync.Mutex
+	series map[string]*counterSeries
+}
+
+type counterSeries struct {
+	labelValues []string
+	value       float64
+}
+
+// NewCounter registers a Counter, with values for each of labels.
+func NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{
+		name:   name,
+		help:   help,
+		labels: labels,
+		series: make(map[string]*counterSeries),
+	}
+	register(name, c)
+	return c
+}
+
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds v, which mustn't be negative, to the counter with labelValues, in the order of its labels.
+func (c *Counter) Add(v float64, labelValues ...string) {
+	key := seriesKey(labelValues)
+
+	c.mutex.Lock()
+	s, ok := c.series[key]
+	if !ok {
+		s = &counterSeries{labelValues: append([]string(nil), labelValues...)}
+		c.series[key] = s
+	}
+	s.value += v
+	c.mutex.Unlock()
+}
+
+func (c *Counter) write(buf *bytes.Buffer) {
+	writeHeader(buf, c.name, c.help, "counter")
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for _, key := range sortedKeys(c.series) {
+		s := c.series[key]
+		writeSample(buf, c.name, c.labels, s.labelValues, "", "", s.value)
+	}
+}
+
+// Histogram counts observations (ie: request durations) into buckets, for each combination of its labels' values.
+type Histogram struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+
+	mutex  sync.Mutex
+	series map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	labelValues []string
+	counts      []uint64 // per bucket, not cumulative
+	sum         float64
+	count       uint64
+}
+
+// NewHistogram registers a Histogram, with buckets' upper bounds in increasing order, and values for each of labels.
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	h := &Histogram{
+		name:    name,
+		help:    help,
+		labels:  labels,
+		buckets: buckets,
+		series:  make(map[string]*histogramSeries),
+	}
+	register(name, h)
+	return h
+}
+
+// Observe adds v to the histogram with labelValues, in the order of its labels.
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	key := seriesKey(labelValues)
+	bucket := sort.SearchFloat64s(h.buckets, v)
+
+	h.mutex.Lock()
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogramSeries{
+			labelValues: append([]string(nil), labelValues...),
+			counts:      make([]uint64, len(h.buckets)),
+		}
+		h.series[key] = s
+	}
+	if bucket < len(h.buckets) {
+		s.counts[bucket]++
+	}
+	s.sum += v
+	s.count++
+	h.mutex.Unlock()
+}
+
+func (h *Histogram) write(buf *bytes.Buffer) {
+	writeHeader(buf, h.name, h.help, "histogram")
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	for _, key := range sortedKeys(h.series) {
+		s := h.series[key]
+
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += s.counts[i]
+			writeSample(buf, h.name+"_bucket", h.labels, s.labelValues, "le", formatFloat(bound), float64(cumulative))
+		}
+		writeSample(buf, h.name+"_bucket", h.labels, s.labelValues, "le", "+Inf", float64(s.count))
+		writeSample(buf, h.name+"_sum", h.labels, s.labelValues, "", "", s.sum)
+		writeSample(buf, h.name+"_count", h.labels, s.labelValues, "", "", float64(s.count))
+	}
+}
+
+// funcMetric's value is read when it's served, ie: from another package's stats.
+type funcMetric struct {
+	name string
+	help string
+	kind string
+	fn   func() float64
+}
+
+// NewGaugeFunc registers a gauge (a value that can go up and down) that's the result of fn.
+func NewGaugeFunc(name, help string, fn func() float64) {
+	register(name, &funcMetric{name: name, help: help, kind: "gauge", fn: fn})
+}
+
+// NewCounterFunc registers a counter that's the result of fn, which must only go up.
+func NewCounterFunc(name, help string, fn func() float64) {
+	register(name, &funcMetric{name: name, help: help, kind: "counter", fn: fn})
+}
+
+func (m *funcMetric) write(buf *bytes.Buffer) {
+	writeHeader(buf, m.name, m.help, m.kind)
+	writeSample(buf, m.name, nil, nil, "", "", m.fn())
+}
+
+func writeHeader(buf *bytes.Buffer, name, help, kind string) {
+	buf.WriteString("# HELP ")
+	buf.WriteString(name)
+	buf.WriteByte(' ')
+	buf.WriteString(strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help))
+	buf.WriteString("\n# TYPE ")
+	buf.WriteString(name)
+	buf.WriteByte(' ')
+	buf.WriteString(kind)
+	buf.WriteByte('\n')
+}
+
+// writeSample writes a line of name's value, labelled by labels and their values, and extra (ie: a histogram's "le").
+func writeSample(buf *bytes.Buffer, name string, labels, values []string, extra, extraValue string, v float64) {
+	buf.WriteString(name)
+
+	if len(labels) != 0 || extra != "" {
+		buf.WriteByte('{')
+		for i, label := range labels {
+			if i != 0 {
+				buf.WriteByte(',')
+			}
+
+			var value string
+			if i < len(values) {
+				value = values[i]
+			}
+			writeLabel(buf, label, value)
+		}
+		if extra != "" {
+			if len(labels) != 0 {
+				buf.WriteByte(',')
+			}
+			writeLabel(buf, extra, extraValue)
+		}
+		buf.WriteByte('}')
+	}
+
+	buf.WriteByte(' ')
+	buf.WriteString(formatFloat(v))
+	buf.WriteByte('\n')
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func writeLabel(buf *bytes.Buffer, label, value string) {
+	buf.WriteString(label)
+	buf.WriteString(`="`)
+	buf.WriteString(labelEscaper.Replace(value))
+	buf.WriteByte('"')
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
+
+// label values can't hold invalid UTF-8, so neither can a separator of them
+func seriesKey(labelValues []string) string {
+	return strings.Join(labelValues, "\xff")
+}
+
+func sortedKeys(series interface{}) (keys []string) {
+	switch s := series.(type) {
+	case map[string]*counterSeries:
+		for key := range s {
+			keys = append(keys, key)
+		}
+	case map[string]*histogramSeries:
+		for key := range s {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return
+}
@@ -0,0 +1,116 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/dabbertorres/web-srv-base/audit"
+	"github.com/dabbertorres/web-srv-base/db"
+	"github.com/dabbertorres/web-srv-base/dialogue"
+	"github.com/dabbertorres/web-srv-base/logme"
+	"github.com/dabbertorres/web-srv-base/model"
+	"github.com/dabbertorres/web-srv-base/perms"
+	"github.com/dabbertorres/web-srv-base/tokens"
+)
+
+const (
+	activityVisits = 100
+	activityLogins = 50
+
+	// how far back to look for visits
+	activityPeriod = 30 * 24 * time.Hour
+)
+
+type userActivity struct {
+	User     string                 `json:"user"`
+	Visits   []db.Visit             `json:"visits,omitempty"`
+	Logins   []db.AuditEvent        `json:"logins"`
+	Sessions []dialogue.SessionInfo `json:"sessions"`
+}
+
+// UserActivity returns a user's most recent visits (of the last 30 days), logins (and failed attempts to), and the
+// sessions they're logged in to. Times are shown in the "tz" time zone, if given.
+// Visits are left out unless the caller may view visits (and their token, if any, may read them).
+func UserActivity(w http.ResponseWriter, r *http.Request) {
+	username := mux.Vars(r)["name"]
+
+	exists, err := db.UserExists(r.Context(), username)
+	if err != nil {
+		model.Log(logme.LevelError, r, err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if !exists {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	loc, err := reqLocation(r)
+	if err != nil {
+		model.Log(logme.LevelError, r, err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if loc == nil {
+		loc = time.UTC
+	}
+
+	viewVisits, err := perms.Has(r, perms.VisitsView)
+	if err != nil {
+		model.Log(logme.LevelError, r, err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	activity := userActivity{
+		User: username,
+	}
+
+	if viewVisits && tokens.Allows(r, tokens.ScopeVisitsRead) {
+		now := time.Now().UTC()
+		activity.Visits = make([]db.Visit, 0, activityVisits)
+
+		err = db.VisitsEach(r.Context(), &db.VisitFilter{
+			Start:  now.Add(-activityPeriod),
+			End:    now,
+			User:   username,
+			Limit:  activityVisits,
+			Newest: true,
+		}, loc, func(v *db.Visit) error {
+			activity.Visits = append(activity.Visits, *v)
+			return nil
+		})
+		if err != nil {
+			model.Log(logme.LevelError, r, err.Error())
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+
+	activity.Logins, err = db.AuditEvents(r.Context(), &db.AuditFilter{
+		Target:  username,
+		Actions: []string{audit.Login, audit.LoginFailed},
+		Limit:   activityLogins,
+	}, loc)
+	if err != nil {
+		model.Log(logme.LevelError, r, err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	activity.Sessions, err = dialogue.Sessions(r, username)
+	if err != nil {
+		model.Log(logme.LevelError, r, err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(&activity)
+	if err != nil {
+		model.Log(logme.LevelError, r, err.Error())
+	}
+}
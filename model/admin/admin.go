@@ -3,9 +3,11 @@ package admin
 import (
 	"net/http"
 
-	"github.com/dabbertorres/web-srv-base/db"
+	"github.com/dabbertorres/web-srv-base/audit"
 	"github.com/dabbertorres/web-srv-base/dialogue"
 	"github.com/dabbertorres/web-srv-base/logme"
+	"github.com/dabbertorres/web-srv-base/model"
+	"github.com/dabbertorres/web-srv-base/perms"
 )
 
 func Middleware(next http.Handler) http.Handler {
@@ -15,22 +17,24 @@ func Middleware(next http.Handler) http.Handler {
 		if !loggedIn {
 			err := dialogue.SaveLocation(r)
 			if err != nil {
-				logme.Warn().Println("saving location for session:", err)
+				model.Log(logme.LevelWarn, r, "saving location for session: "+err.Error())
 			}
 
 			http.Redirect(w, r, "/login", http.StatusFound)
 			return
 		}
 
-		admin, err := db.UserIsAdmin(r.Context(), username)
+		// anyone with any permission has some business in the admin site - routes check for specific ones
+		granted, err := perms.Of(r)
 		if err != nil {
-			logme.Err().Println("checking if user is an admin:", err)
+			model.Log(logme.LevelError, r, "checking user permissions: "+err.Error())
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
 
-		if !admin {
-			logme.Warn().Println("non-admin attempt to access admin page by:", username)
+		if len(granted) == 0 {
+			model.Log(logme.LevelWarn, r, "non-admin attempt to access admin page by: "+username)
+			audit.Record(r, audit.AccessDenied, r.URL.Path, nil)
 			w.WriteHeader(http.StatusUnauthorized)
 			return
 		}
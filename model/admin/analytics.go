@@ -0,0 +1,135 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/dabbertorres/web-srv-base/db"
+	"github.com/dabbertorres/web-srv-base/logme"
+	"github.com/dabbertorres/web-srv-base/model"
+)
+
+const (
+	topDefaultLimit = 10
+	topMaxLimit     = 100
+)
+
+// VisitsTraffic returns page views, unique visitors, error counts, and views by bots per "bucket" (hour, day, or week).
+func VisitsTraffic(w http.ResponseWriter, r *http.Request) {
+	start, end, loc, err := visitsParseTimes(r)
+	if err != nil {
+		model.Log(logme.LevelError, r, err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	bucket := r.FormValue("bucket")
+	if bucket == "" {
+		bucket = "day"
+	}
+
+	results, err := db.VisitsTraffic(r.Context(), start, end, bucket, loc)
+	switch err {
+	case nil:
+
+	case db.ErrUnknownBucket:
+		w.WriteHeader(http.StatusBadRequest)
+		return
+
+	default:
+		model.Log(logme.LevelError, r, err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(results)
+	if err != nil {
+		model.Log(logme.LevelError, r, err.Error())
+	}
+}
+
+// VisitsTop returns the most common values of "by" (path, referrer, userAgent, country, or region), optionally of just one "class" of
+// visitor (human, bot, headless, or automated - any but human).
+func VisitsTop(w http.ResponseWriter, r *http.Request) {
+	start, end, _, err := visitsParseTimes(r)
+	if err != nil {
+		model.Log(logme.LevelError, r, err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	limit, ok := topLimit(r)
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	results, err := db.VisitsTop(r.Context(), r.FormValue("by"), start, end, r.FormValue("class"), limit)
+	switch err {
+	case nil:
+
+	case db.ErrUnknownDimension, db.ErrUnknownClass:
+		w.WriteHeader(http.StatusBadRequest)
+		return
+
+	default:
+		model.Log(logme.LevelError, r, err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(results)
+	if err != nil {
+		model.Log(logme.LevelError, r, err.Error())
+	}
+}
+
+// VisitsCampaigns returns views and visitors per utm_source, utm_medium, and utm_campaign, optionally of just one
+// "class" of visitor.
+func VisitsCampaigns(w http.ResponseWriter, r *http.Request) {
+	start, end, _, err := visitsParseTimes(r)
+	if err != nil {
+		model.Log(logme.LevelError, r, err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	limit, ok := topLimit(r)
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	results, err := db.VisitsCampaigns(r.Context(), start, end, r.FormValue("class"), limit)
+	switch err {
+	case nil:
+
+	case db.ErrUnknownClass:
+		w.WriteHeader(http.StatusBadRequest)
+		return
+
+	default:
+		model.Log(logme.LevelError, r, err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(results)
+	if err != nil {
+		model.Log(logme.LevelError, r, err.Error())
+	}
+}
+
+func topLimit(r *http.Request) (limit int, ok bool) {
+	limitStr := r.FormValue("limit")
+	if limitStr == "" {
+		return topDefaultLimit, true
+	}
+
+	limit, err := strconv.Atoi(limitStr)
+	return limit, err == nil && limit > 0 && limit <= topMaxLimit
+}
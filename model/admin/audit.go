@@ -0,0 +1,114 @@
+package admin
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/dabbertorres/web-srv-base/db"
+	"github.com/dabbertorres/web-srv-base/logme"
+	"github.com/dabbertorres/web-srv-base/model"
+)
+
+var (
+	auditCSVHeader = []string{"id", "time", "actor", "target", "action", "ip", "metadata"}
+)
+
+// Audit returns the audit events matching the "actor", "target", "action", "start", "end", and "limit" parameters.
+// Times are shown in the "tz" time zone, if given.
+// They're returned as JSON, or as CSV if "format" is "csv".
+func Audit(w http.ResponseWriter, r *http.Request) {
+	filter, loc, err := auditParseFilter(r)
+	if err != nil {
+		model.Log(logme.LevelError, r, err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	results, err := db.AuditEvents(r.Context(), &filter, loc)
+	if err != nil {
+		model.Log(logme.LevelError, r, err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if r.FormValue("format") == "csv" {
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", `attachment; filename="audit.csv"`)
+
+		out := csv.NewWriter(w)
+		out.Write(auditCSVHeader)
+		for _, e := range results {
+			out.Write([]string{
+				strconv.FormatInt(e.ID, 10),
+				e.Time.Format(time.RFC3339),
+				e.Actor,
+				e.Target,
+				e.Action,
+				e.IP,
+				e.Metadata,
+			})
+		}
+		out.Flush()
+
+		err = out.Error()
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+		err = json.NewEncoder(w).Encode(results)
+	}
+
+	if err != nil {
+		model.Log(logme.LevelError, r, err.Error())
+	}
+}
+
+func auditParseFilter(r *http.Request) (filter db.AuditFilter, loc *time.Location, err error) {
+	filter.Actor = r.FormValue("actor")
+	filter.Target = r.FormValue("target")
+	filter.Action = r.FormValue("action")
+	now := time.Now()
+
+	tz, err := reqLocation(r)
+	if err != nil {
+		return
+	}
+
+	loc = time.UTC
+	if tz != nil {
+		loc = tz
+	}
+
+	if startStr := r.FormValue("start"); startStr != "" {
+		filter.Start, err = parseReqTime(startStr, loc, now)
+		if err != nil {
+			err = fmt.Errorf("start parameter: %v", err)
+			return
+		}
+		if tz == nil && filter.Start.Location() != time.Local {
+			loc = filter.Start.Location()
+		}
+		filter.Start = filter.Start.UTC()
+	}
+
+	if endStr := r.FormValue("end"); endStr != "" {
+		filter.End, err = parseReqTime(endStr, loc, now)
+		if err != nil {
+			err = fmt.Errorf("end parameter: %v", err)
+			return
+		}
+		filter.End = filter.End.UTC()
+	}
+
+	if limitStr := r.FormValue("limit"); limitStr != "" {
+		filter.Limit, err = strconv.Atoi(limitStr)
+		if err != nil {
+			err = fmt.Errorf("limit parameter: %v", err)
+			return
+		}
+	}
+
+	return
+}
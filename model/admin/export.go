@@ -0,0 +1,208 @@
+package admin
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dabbertorres/web-srv-base/db"
+)
+
+const (
+	formatJSON  = "json"
+	formatJSONL = "jsonl"
+	formatCSV   = "csv"
+
+	visitsDefaultPage = 1000
+	visitsMaxPage     = 10000
+
+	// how often streamed visits are pushed to the client
+	visitsFlushEvery = 500
+)
+
+var (
+	// media types accepted for each format, in the Accept header
+	formatMediaTypes = map[string]string{
+		"application/json":        formatJSON,
+		"application/jsonl":       formatJSONL,
+		"application/x-jsonl":     formatJSONL,
+		"application/x-ndjson":    formatJSONL,
+		"application/x-jsonlines": formatJSONL,
+		"text/csv":                formatCSV,
+	}
+
+	visitsCSVHeader = []string{
+		"id", "time", "user", "ip", "userAgent", "path", "action", "params", "referrer", "impersonator",
+		"status", "bytes", "durationUs", "class", "country", "region",
+		"utmSource", "utmMedium", "utmCampaign", "utmTerm", "utmContent",
+	}
+)
+
+// visitsFormat picks the format to return visits in, from the "format" parameter, or else the Accept header.
+func visitsFormat(r *http.Request) string {
+	switch r.FormValue("format") {
+	case formatCSV:
+		return formatCSV
+	case formatJSONL, "ndjson":
+		return formatJSONL
+	case formatJSON:
+		return formatJSON
+	}
+
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(accept)
+		if err != nil {
+			continue
+		}
+		if format, ok := formatMediaTypes[mediaType]; ok {
+			return format
+		}
+	}
+
+	return formatJSON
+}
+
+type visitWriter interface {
+	Write(v *db.Visit) error
+
+	// Close finishes the response. It must be called, even if nothing was written.
+	Close() error
+}
+
+func newVisitWriter(format string, w http.ResponseWriter, r *http.Request, filter *db.VisitFilter) visitWriter {
+	switch format {
+	case formatCSV:
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", `attachment; filename="visits.csv"`)
+
+		out := &csvVisitWriter{w: w, csv: csv.NewWriter(w)}
+		out.csv.Write(visitsCSVHeader)
+		return out
+
+	case formatJSONL:
+		w.Header().Set("Content-Type", "application/jsonl")
+		return &jsonlVisitWriter{w: w, enc: json.NewEncoder(w)}
+
+	default:
+		return &jsonVisitWriter{
+			w:      w,
+			r:      r,
+			limit:  filter.Limit,
+			visits: make([]db.Visit, 0, filter.Limit),
+		}
+	}
+}
+
+func flush(w http.ResponseWriter) {
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// jsonVisitWriter collects a page of visits, to return as an array, with a link to the next page
+type jsonVisitWriter struct {
+	w      http.ResponseWriter
+	r      *http.Request
+	limit  int
+	visits []db.Visit
+}
+
+func (j *jsonVisitWriter) Write(v *db.Visit) error {
+	j.visits = append(j.visits, *v)
+	return nil
+}
+
+func (j *jsonVisitWriter) Close() error {
+	// a full page means there may be more
+	if len(j.visits) != 0 && len(j.visits) == j.limit {
+		next := *j.r.URL
+		query := next.Query()
+		query.Set("after", strconv.FormatInt(j.visits[len(j.visits)-1].ID, 10))
+		next.RawQuery = query.Encode()
+
+		j.w.Header().Set("Link", "<"+next.RequestURI()+`>; rel="next"`)
+	}
+
+	j.w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(j.w).Encode(j.visits)
+}
+
+type jsonlVisitWriter struct {
+	w       http.ResponseWriter
+	enc     *json.Encoder
+	written int
+}
+
+func (j *jsonlVisitWriter) Write(v *db.Visit) error {
+	err := j.enc.Encode(v)
+	if err != nil {
+		return err
+	}
+
+	j.written++
+	if j.written%visitsFlushEvery == 0 {
+		flush(j.w)
+	}
+	return nil
+}
+
+func (j *jsonlVisitWriter) Close() error {
+	return nil
+}
+
+type csvVisitWriter struct {
+	w       http.ResponseWriter
+	csv     *csv.Writer
+	written int
+}
+
+func (c *csvVisitWriter) Write(v *db.Visit) error {
+	var ip string
+	if v.IP != nil {
+		ip = v.IP.String()
+	}
+
+	err := c.csv.Write([]string{
+		strconv.FormatInt(v.ID, 10),
+		v.Time.Format(time.RFC3339),
+		v.User,
+		ip,
+		v.UserAgent,
+		v.Path,
+		v.Method,
+		v.Params,
+		v.Referrer,
+		v.Impersonator,
+		strconv.Itoa(v.Status),
+		strconv.FormatInt(v.Bytes, 10),
+		strconv.FormatInt(v.Duration, 10),
+		v.Class,
+		v.Country,
+		v.Region,
+		v.Campaign.Source,
+		v.Campaign.Medium,
+		v.Campaign.Name,
+		v.Campaign.Term,
+		v.Campaign.Content,
+	})
+	if err != nil {
+		return err
+	}
+
+	c.written++
+	if c.written%visitsFlushEvery == 0 {
+		c.csv.Flush()
+		flush(c.w)
+		return c.csv.Error()
+	}
+	return nil
+}
+
+func (c *csvVisitWriter) Close() error {
+	c.csv.Flush()
+	return c.csv.Error()
+}
@@ -0,0 +1,79 @@
+package admin
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"github.com/dabbertorres/web-srv-base/audit"
+	"github.com/dabbertorres/web-srv-base/db"
+	"github.com/dabbertorres/web-srv-base/dialogue"
+	"github.com/dabbertorres/web-srv-base/logme"
+	"github.com/dabbertorres/web-srv-base/model"
+	"github.com/dabbertorres/web-srv-base/perms"
+)
+
+// Impersonate switches the admin's session to act as the user named in the path, until they stop impersonating.
+func Impersonate(w http.ResponseWriter, r *http.Request) {
+	var (
+		_, admin = dialogue.IsLoggedIn(r)
+		target   = mux.Vars(r)["name"]
+	)
+
+	if target == admin {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	exists, err := db.UserExists(r.Context(), target)
+	if err != nil {
+		model.Log(logme.LevelError, r, err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if !exists {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	// impersonating must never grant the admin more than they already have
+	adminPerms, err := db.UserPermissions(r.Context(), admin)
+	if err != nil {
+		model.Log(logme.LevelError, r, err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	targetPerms, err := db.UserPermissions(r.Context(), target)
+	if err != nil {
+		model.Log(logme.LevelError, r, err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// nor let them act as another impersonator
+	if !perms.Subset(targetPerms, adminPerms) || perms.Contains(targetPerms, perms.UsersImpersonate) {
+		model.Log(logme.LevelWarn, r, "'"+admin+"' may not impersonate '"+target+"'")
+		audit.Record(r, audit.AccessDenied, r.URL.Path, audit.Meta{"target": target})
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	err = dialogue.Impersonate(r, target)
+	switch err {
+	case nil:
+		audit.Record(r, audit.ImpersonateStart, target, nil)
+
+	case dialogue.ErrImpersonating:
+		w.WriteHeader(http.StatusConflict)
+		return
+
+	default:
+		model.Log(logme.LevelError, r, err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	model.Log(logme.LevelInfo, r, "'"+admin+"' started impersonating '"+target+"'")
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
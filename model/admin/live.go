@@ -0,0 +1,103 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/dabbertorres/web-srv-base/db"
+	"github.com/dabbertorres/web-srv-base/logme"
+	"github.com/dabbertorres/web-srv-base/model"
+	"github.com/dabbertorres/web-srv-base/visitors"
+)
+
+const (
+	// visits held for a live feed that isn't keeping up, before they're dropped
+	liveBuffer = 256
+
+	// keeps proxies from closing idle feeds
+	liveKeepAlive = 15 * time.Second
+)
+
+// VisitsLive streams visits as they happen, as Server-Sent Events: "visit" events of a visit as JSON, and "dropped"
+// events of the total number of visits that were skipped because the feed fell behind.
+// Visits can be narrowed down by the same parameters as Visits, other than times.
+func VisitsLive(w http.ResponseWriter, r *http.Request) {
+	var filter db.VisitFilter
+	err := visitsParseConditions(r, &filter)
+	if err == nil {
+		err = filter.Validate()
+	}
+	if err != nil {
+		model.Log(logme.LevelError, r, err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		model.Log(logme.LevelError, r, "response can't be streamed")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	sub := visitors.Subscribe(filter, liveBuffer)
+	defer sub.Unsubscribe()
+
+	// nothing below needs the db, and a feed may be watched for hours
+	db.Release(r.Context())
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(liveKeepAlive)
+	defer keepAlive.Stop()
+
+	var (
+		id      uint64
+		dropped uint64
+	)
+	for {
+		select {
+		case visit, ok := <-sub.C:
+			if !ok {
+				return
+			}
+
+			data, err := json.Marshal(&visit)
+			if err != nil {
+				model.Log(logme.LevelError, r, err.Error())
+				continue
+			}
+
+			id++
+			_, err = fmt.Fprintf(w, "id: %d\nevent: visit\ndata: %s\n\n", id, data)
+			if err != nil {
+				return
+			}
+
+			if d := sub.Dropped(); d != dropped {
+				dropped = d
+				_, err = fmt.Fprintf(w, "event: dropped\ndata: %d\n\n", dropped)
+				if err != nil {
+					return
+				}
+			}
+
+		case <-keepAlive.C:
+			_, err := fmt.Fprint(w, ": keep-alive\n\n")
+			if err != nil {
+				return
+			}
+
+		case <-r.Context().Done():
+			return
+		}
+
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,130 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"github.com/dabbertorres/web-srv-base/audit"
+	"github.com/dabbertorres/web-srv-base/db"
+	"github.com/dabbertorres/web-srv-base/logme"
+	"github.com/dabbertorres/web-srv-base/model"
+	"github.com/dabbertorres/web-srv-base/perms"
+)
+
+func Roles(w http.ResponseWriter, r *http.Request) {
+	results, err := db.Roles(r.Context())
+	if err != nil {
+		model.Log(logme.LevelError, r, err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(results)
+	if err != nil {
+		model.Log(logme.LevelError, r, err.Error())
+	}
+}
+
+// RoleSet creates or replaces the role named in the path, with the "description" and "permission" form values.
+func RoleSet(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		model.Log(logme.LevelWarn, r, err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	role := db.Role{
+		Name:        mux.Vars(r)["role"],
+		Description: r.Form.Get("description"),
+		Permissions: r.Form["permission"],
+	}
+
+	for _, p := range role.Permissions {
+		if !perms.Valid(p) {
+			model.Log(logme.LevelWarn, r, "unknown permission: "+p)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+
+	err = db.RoleSet(r.Context(), &role)
+	if err != nil {
+		model.Log(logme.LevelError, r, err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	audit.Record(r, audit.RoleSet, role.Name, audit.Meta{"permissions": role.Permissions})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func RoleDelete(w http.ResponseWriter, r *http.Request) {
+	role := mux.Vars(r)["role"]
+
+	err := db.RoleDelete(r.Context(), role)
+	switch err {
+	case nil:
+		audit.Record(r, audit.RoleDelete, role, nil)
+		w.WriteHeader(http.StatusNoContent)
+
+	case db.ErrRoleNotExist:
+		w.WriteHeader(http.StatusNotFound)
+
+	default:
+		model.Log(logme.LevelError, r, err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+func UserRoles(w http.ResponseWriter, r *http.Request) {
+	results, err := db.UserRoles(r.Context(), mux.Vars(r)["name"])
+	if err != nil {
+		model.Log(logme.LevelError, r, err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(results)
+	if err != nil {
+		model.Log(logme.LevelError, r, err.Error())
+	}
+}
+
+func UserAddRole(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	err := db.UserAddRole(r.Context(), vars["name"], vars["role"])
+	switch err {
+	case nil:
+		audit.Record(r, audit.RoleAssign, vars["name"], audit.Meta{"role": vars["role"]})
+		w.WriteHeader(http.StatusNoContent)
+
+	case db.ErrUserOrRoleNotExist:
+		w.WriteHeader(http.StatusNotFound)
+
+	default:
+		model.Log(logme.LevelError, r, err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+func UserRemoveRole(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	err := db.UserRemoveRole(r.Context(), vars["name"], vars["role"])
+	if err != nil {
+		model.Log(logme.LevelError, r, err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	audit.Record(r, audit.RoleUnassign, vars["name"], audit.Meta{"role": vars["role"]})
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,33 @@
+package admin
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"github.com/dabbertorres/web-srv-base/audit"
+	"github.com/dabbertorres/web-srv-base/dialogue"
+	"github.com/dabbertorres/web-srv-base/logme"
+	"github.com/dabbertorres/web-srv-base/model"
+)
+
+// SessionRevoke logs a user out of one of their sessions, as listed by UserActivity.
+func SessionRevoke(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	username := vars["name"]
+	id := vars["id"]
+
+	err := dialogue.Revoke(username, id)
+	switch err {
+	case nil:
+		audit.Record(r, audit.SessionRevoke, username, audit.Meta{"session": id})
+		w.WriteHeader(http.StatusNoContent)
+
+	case dialogue.ErrSessionNotExist:
+		w.WriteHeader(http.StatusNotFound)
+
+	default:
+		model.Log(logme.LevelError, r, err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
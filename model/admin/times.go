@@ -0,0 +1,85 @@
+package admin
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// almost RFC3339/ISO8061 - it has no seconds
+	reqTimeLayout = "2006-01-02T15:04Z0700"
+)
+
+var (
+	// accepted time parameter layouts, tried in order. Those without a zone are in the "tz" parameter's.
+	reqTimeLayouts = []string{
+		time.RFC3339Nano, // also matches RFC3339
+		reqTimeLayout,
+		"2006-01-02T15:04:05",
+		"2006-01-02T15:04",
+		"2006-01-02",
+	}
+)
+
+// reqLocation returns the time zone named by the "tz" parameter (ie: "America/Los_Angeles"), or nil if there isn't one.
+func reqLocation(r *http.Request) (loc *time.Location, err error) {
+	tz := r.FormValue("tz")
+	if tz == "" {
+		return
+	}
+
+	loc, err = time.LoadLocation(tz)
+	if err != nil {
+		err = fmt.Errorf("tz parameter: %v", err)
+	}
+	return
+}
+
+// parseReqTime parses a time parameter: "now", a time relative to now (ie: "-24h", "-7d", "-2w"), or a time in any of
+// reqTimeLayouts. Times without a zone are in loc.
+func parseReqTime(value string, loc *time.Location, now time.Time) (t time.Time, err error) {
+	if value == "now" {
+		return now, nil
+	}
+
+	if strings.HasPrefix(value, "-") {
+		var ago time.Duration
+		ago, err = parseAgo(value[1:])
+		if err != nil {
+			return
+		}
+		return now.Add(-ago), nil
+	}
+
+	for _, layout := range reqTimeLayouts {
+		t, err = time.ParseInLocation(layout, value, loc)
+		if err == nil {
+			return
+		}
+	}
+
+	err = fmt.Errorf("%q is not a time, or relative time", value)
+	return
+}
+
+// parseAgo parses a duration, with days ("d") and weeks ("w") in addition to time.ParseDuration's units
+func parseAgo(value string) (time.Duration, error) {
+	var unit time.Duration
+	switch {
+	case strings.HasSuffix(value, "d"):
+		unit = 24 * time.Hour
+	case strings.HasSuffix(value, "w"):
+		unit = 7 * 24 * time.Hour
+	default:
+		return time.ParseDuration(value)
+	}
+
+	n, err := strconv.Atoi(value[:len(value)-1])
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid relative time %q", "-"+value)
+	}
+	return time.Duration(n) * unit, nil
+}
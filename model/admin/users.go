@@ -0,0 +1,51 @@
+package admin
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"github.com/dabbertorres/web-srv-base/audit"
+	"github.com/dabbertorres/web-srv-base/db"
+	"github.com/dabbertorres/web-srv-base/logme"
+	"github.com/dabbertorres/web-srv-base/model"
+)
+
+func UserEnable(w http.ResponseWriter, r *http.Request) {
+	userSetEnabled(w, r, true)
+}
+
+func UserDisable(w http.ResponseWriter, r *http.Request) {
+	userSetEnabled(w, r, false)
+}
+
+func userSetEnabled(w http.ResponseWriter, r *http.Request, enabled bool) {
+	username := mux.Vars(r)["name"]
+
+	exists, err := db.UserExists(r.Context(), username)
+	if err != nil {
+		model.Log(logme.LevelError, r, err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if !exists {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	// UserSetEnabled reports no change as an error, but setting it to what it already is is fine
+	err = db.UserSetEnabled(r.Context(), username, enabled)
+	if err != nil && err != db.ErrUserDisabledOrNotExist {
+		model.Log(logme.LevelError, r, err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if enabled {
+		audit.Record(r, audit.UserEnable, username, nil)
+	} else {
+		audit.Record(r, audit.UserDisable, username, nil)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -1,10 +1,12 @@
 package admin
 
 import (
-	"encoding/json"
 	"errors"
 	"fmt"
+	"net"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/dabbertorres/web-srv-base/db"
@@ -12,62 +14,229 @@ import (
 	"github.com/dabbertorres/web-srv-base/model"
 )
 
-const (
-	// almost RFC3339/ISO8061 - it has no seconds
-	reqTimeLayout = "2006-01-02T15:04Z0700"
-)
-
+// Visits returns the visits between "start" and "end", in ID order. They can be narrowed down by:
+//   - "class": of visitor - human, bot, headless, or automated (any but human)
+//   - "user": the user's name
+//   - "ip": an address or CIDR
+//   - "path": a prefix, or a glob with '*' and '?'
+//   - "method": ie: GET
+//   - "status": a code, class (ie: 4xx), or inclusive range (ie: 500-503)
+//   - "userAgent": a substring of it
+//
+// Times are shown in the "tz" time zone, if given. They're oldest first, or newest first if "order" is "desc".
+//
+// As JSON (the default), they're returned a page at a time: up to "limit" visits with IDs greater than "after". If
+// there may be more, a Link header refers to the next page.
+// As CSV ("format" is "csv", or "Accept" is text/csv) or JSON Lines ("format" is "jsonl" or "ndjson", or "Accept" is
+// application/jsonl or application/x-ndjson), they're streamed, all of them unless "limit" is set. An interrupted
+// export can be resumed by passing the last ID received as "after" (with the same "order").
 func Visits(w http.ResponseWriter, r *http.Request) {
-	start, end, loc, err := visitsParseTimes(r)
+	filter, loc, err := visitsParseFilter(r)
 	if err != nil {
-		model.Log(logme.Err(), r, err.Error())
+		model.Log(logme.LevelError, r, err.Error())
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
-	results, err := db.VisitsBetween(r.Context(), start, end, loc)
-	if err != nil {
-		model.Log(logme.Err(), r, err.Error())
+	format := visitsFormat(r)
+	if format == formatJSON {
+		if filter.Limit > visitsMaxPage {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if filter.Limit <= 0 {
+			filter.Limit = visitsDefaultPage
+		}
+	}
+
+	// nothing can be written before the first visit is read, so errors like an unknown class still get a status
+	var out visitWriter
+	err = db.VisitsEach(r.Context(), &filter, loc, func(v *db.Visit) error {
+		if out == nil {
+			out = newVisitWriter(format, w, r, &filter)
+		}
+		return out.Write(v)
+	})
+
+	if out == nil && err == nil {
+		out = newVisitWriter(format, w, r, &filter)
+	}
+	if out != nil {
+		closeErr := out.Close()
+		if err == nil {
+			err = closeErr
+		}
+	}
+
+	switch {
+	case err == nil:
+
+	case out == nil && err == db.ErrUnknownClass:
+		w.WriteHeader(http.StatusBadRequest)
+
+	case out == nil:
+		model.Log(logme.LevelError, r, err.Error())
 		w.WriteHeader(http.StatusInternalServerError)
+
+	default:
+		// too late to change the status - the client sees a truncated response
+		model.Log(logme.LevelError, r, err.Error())
+	}
+}
+
+func visitsParseFilter(r *http.Request) (filter db.VisitFilter, loc *time.Location, err error) {
+	filter.Start, filter.End, loc, err = visitsParseTimes(r)
+	if err != nil {
 		return
 	}
 
-	err = json.NewEncoder(w).Encode(results)
+	err = visitsParseConditions(r, &filter)
 	if err != nil {
-		model.Log(logme.Err(), r, err.Error())
-		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+
+	filter.Newest = r.FormValue("order") == "desc"
+
+	if afterStr := r.FormValue("after"); afterStr != "" {
+		filter.After, err = strconv.ParseInt(afterStr, 10, 64)
+		if err != nil {
+			err = fmt.Errorf("after parameter: %v", err)
+			return
+		}
+	}
+
+	if limitStr := r.FormValue("limit"); limitStr != "" {
+		filter.Limit, err = strconv.Atoi(limitStr)
+		if err != nil {
+			err = fmt.Errorf("limit parameter: %v", err)
+			return
+		}
+	}
+
+	return
 }
 
+// visitsParseConditions parses the parameters that narrow down visits, other than by time - see Visits.
+func visitsParseConditions(r *http.Request, filter *db.VisitFilter) (err error) {
+	filter.Class = r.FormValue("class")
+	filter.User = r.FormValue("user")
+	filter.Path = r.FormValue("path")
+	filter.Method = strings.ToUpper(r.FormValue("method"))
+	filter.UserAgent = r.FormValue("userAgent")
+
+	if ipStr := r.FormValue("ip"); ipStr != "" {
+		filter.Network, err = parseNetwork(ipStr)
+		if err != nil {
+			err = fmt.Errorf("ip parameter: %v", err)
+			return
+		}
+	}
+
+	if statusStr := r.FormValue("status"); statusStr != "" {
+		filter.StatusMin, filter.StatusMax, err = parseStatus(statusStr)
+		if err != nil {
+			err = fmt.Errorf("status parameter: %v", err)
+			return
+		}
+	}
+
+	return
+}
+
+// visitsParseTimes parses the "start" (required) and "end" (default: now) parameters - see parseReqTime.
+// loc is that of the "tz" parameter if there is one, or else of start, so results can be shown in it.
 func visitsParseTimes(r *http.Request) (start, end time.Time, loc *time.Location, err error) {
 	var (
 		startStr = r.FormValue("start")
 		endStr   = r.FormValue("end")
+		now      = time.Now()
 	)
 	if startStr == "" {
 		err = errors.New("did not have start parameter")
 		return
 	}
 
-	start, err = time.ParseInLocation(reqTimeLayout, startStr, time.UTC)
+	loc, err = reqLocation(r)
+	if err != nil {
+		return
+	}
+
+	parseLoc := loc
+	if parseLoc == nil {
+		parseLoc = time.UTC
+	}
+
+	start, err = parseReqTime(startStr, parseLoc, now)
 	if err != nil {
 		err = fmt.Errorf("start parameter: %v", err)
 		return
 	}
 
+	end = now
 	if endStr != "" {
-		end, err = time.ParseInLocation(reqTimeLayout, endStr, time.UTC)
+		end, err = parseReqTime(endStr, parseLoc, now)
 		if err != nil {
 			err = fmt.Errorf("end parameter: %v", err)
 			return
 		}
-	} else {
-		end = time.Now()
 	}
 
-	loc = start.Location()
+	if loc == nil {
+		loc = start.Location()
+		if loc == time.Local {
+			// relative to now
+			loc = time.UTC
+		}
+	}
 	start = start.UTC()
 	end = end.UTC()
 	return
 }
+
+// parseNetwork parses an address, or a CIDR
+func parseNetwork(value string) (network *net.IPNet, err error) {
+	if strings.Contains(value, "/") {
+		_, network, err = net.ParseCIDR(value)
+		return
+	}
+
+	ip := net.ParseIP(value)
+	if ip == nil {
+		err = fmt.Errorf("%q is not an IP address or CIDR", value)
+		return
+	}
+
+	bits := 8 * net.IPv6len
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+		bits = 8 * net.IPv4len
+	}
+	network = &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}
+	return
+}
+
+// parseStatus parses a status code (ie: "404"), a class of them (ie: "4xx"), or an inclusive range (ie: "400-499")
+func parseStatus(value string) (min, max int, err error) {
+	switch {
+	case len(value) == 3 && strings.HasSuffix(strings.ToLower(value), "xx"):
+		min, err = strconv.Atoi(value[:1])
+		min *= 100
+		max = min + 99
+
+	case strings.Contains(value, "-"):
+		parts := strings.SplitN(value, "-", 2)
+		min, err = strconv.Atoi(parts[0])
+		if err == nil {
+			max, err = strconv.Atoi(parts[1])
+		}
+
+	default:
+		min, err = strconv.Atoi(value)
+		max = min
+	}
+
+	if err == nil && (min < 100 || max > 599 || min > max) {
+		err = fmt.Errorf("%q is not a status code, class, or range", value)
+	}
+	return
+}
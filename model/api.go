@@ -1,11 +1,19 @@
 package model
 
 import (
-	"log"
 	"net/http"
 
+	"github.com/dabbertorres/web-srv-base/logme"
+	"github.com/dabbertorres/web-srv-base/realip"
 )
 
-func Log(logger *log.Logger, r *http.Request, why string) {
-	logger.Printf("API %s '%s' (%s, %s): %s\n", r.Method, r.RequestURI, r.RemoteAddr, r.UserAgent(), why)
+var logger = logme.For("api")
+
+// Log writes a record of why r failed (or is notable), with the request's ID, user, and route (see logme.Logger.Ctx).
+func Log(level logme.Level, r *http.Request, why string) {
+	logger.Ctx(r.Context()).Log(level, why,
+		"method", r.Method,
+		"uri", r.RequestURI,
+		"ip", realip.String(r),
+		"userAgent", r.UserAgent())
 }
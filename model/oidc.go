@@ -0,0 +1,207 @@
+package model
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/dabbertorres/web-srv-base/audit"
+	"github.com/dabbertorres/web-srv-base/db"
+	"github.com/dabbertorres/web-srv-base/dialogue"
+	"github.com/dabbertorres/web-srv-base/logme"
+	"github.com/dabbertorres/web-srv-base/oidc"
+)
+
+const (
+	maxUsernameLen = 32
+)
+
+var (
+	usernameInvalidChars = regexp.MustCompile(`[^a-zA-Z0-9_.-]`)
+)
+
+// OIDCLogin starts an external login by redirecting to the identity provider.
+func OIDCLogin(w http.ResponseWriter, r *http.Request) {
+	if !oidc.Enabled() {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	state, nonce, verifier, err := oidc.NewChallenge()
+	if err != nil {
+		Log(logme.LevelError, r, "generating oidc challenge: "+err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	err = dialogue.SetAuthRequest(r, state, nonce, verifier)
+	if err != nil {
+		Log(logme.LevelError, r, "saving oidc challenge: "+err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	authURL, err := oidc.AuthURL(state, nonce, verifier)
+	if err != nil {
+		Log(logme.LevelError, r, "building oidc auth url: "+err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// OIDCCallback completes an external login. The identity is:
+//   - logged in as its linked user, if it has one
+//   - linked to the current user, if already logged in
+//   - otherwise used to create a new user
+func OIDCCallback(w http.ResponseWriter, r *http.Request) {
+	if !oidc.Enabled() {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	state, nonce, verifier, err := dialogue.TakeAuthRequest(r)
+	if err != nil {
+		Log(logme.LevelError, r, "loading oidc challenge: "+err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if !oidc.ValidState(state, r.FormValue("state")) {
+		Log(logme.LevelWarn, r, "oidc state mismatch")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if providerErr := r.FormValue("error"); providerErr != "" {
+		Log(logme.LevelInfo, r, "oidc provider error: "+providerErr+" "+r.FormValue("error_description"))
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
+
+	claims, err := oidc.Exchange(r.Context(), r.FormValue("code"), verifier, nonce)
+	if err != nil {
+		Log(logme.LevelWarn, r, "oidc code exchange: "+err.Error())
+		audit.Record(r, audit.LoginFailed, "", audit.Meta{"method": "oidc", "reason": err.Error()})
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	loggedIn, current := dialogue.IsLoggedIn(r)
+
+	linked, err := db.IdentityUser(r.Context(), claims.Issuer, claims.Subject)
+	switch {
+	case err == nil:
+		if loggedIn {
+			if linked != current {
+				Log(logme.LevelWarn, r, "oidc identity is linked to '"+linked+"', not the logged in user '"+current+"'")
+				w.WriteHeader(http.StatusConflict)
+				return
+			}
+			break
+		}
+
+		enabled, err := db.UserIsEnabled(r.Context(), linked)
+		if err != nil {
+			Log(logme.LevelError, r, "checking if user is enabled: "+err.Error())
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if !enabled {
+			Log(logme.LevelWarn, r, "oidc login attempt for disabled user: "+linked)
+			audit.Record(r, audit.LoginFailed, linked, audit.Meta{"method": "oidc", "reason": "disabled"})
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		err = dialogue.Login(r, linked)
+		if err != nil {
+			Log(logme.LevelError, r, "logging in: "+err.Error())
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		audit.Record(r, audit.Login, linked, audit.Meta{"method": "oidc", "issuer": claims.Issuer})
+
+	case err == db.ErrIdentityNotExist && loggedIn:
+		err = db.IdentityLink(r.Context(), claims.Issuer, claims.Subject, current)
+		if err != nil {
+			Log(logme.LevelError, r, "linking oidc identity: "+err.Error())
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		audit.Record(r, audit.IdentityLink, current, audit.Meta{"issuer": claims.Issuer, "subject": claims.Subject})
+
+	case err == db.ErrIdentityNotExist:
+		username := oidcUsername(&claims)
+		if username == "" || claims.Email == "" {
+			Log(logme.LevelWarn, r, "oidc identity has no usable username or email: "+claims.Subject)
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		// the address is used for password resets and notifications, so only trust one the provider vouches for
+		if !claims.EmailVerified {
+			Log(logme.LevelWarn, r, "oidc identity has an unverified email: "+claims.Subject)
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		// never silently take over an existing account - its owner must log in and link the identity themselves
+		exists, err := db.UserExists(r.Context(), username)
+		if err != nil {
+			Log(logme.LevelError, r, "checking oidc username: "+err.Error())
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if exists {
+			Log(logme.LevelWarn, r, "oidc username is already taken: "+username)
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+
+		err = db.UserNewExternal(r.Context(), username, claims.Email, claims.Issuer, claims.Subject)
+		if err != nil {
+			Log(logme.LevelError, r, "creating oidc user: "+err.Error())
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		audit.Record(r, audit.UserCreate, username, audit.Meta{"method": "oidc", "issuer": claims.Issuer, "subject": claims.Subject})
+
+		err = dialogue.Login(r, username)
+		if err != nil {
+			Log(logme.LevelError, r, "logging in: "+err.Error())
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		audit.Record(r, audit.Login, username, audit.Meta{"method": "oidc", "issuer": claims.Issuer})
+
+	default:
+		Log(logme.LevelError, r, "looking up oidc identity: "+err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	location, err := dialogue.GetLastLocation(r)
+	if err != nil || location == "" || strings.HasPrefix(location, "/login") {
+		location = "/"
+	}
+	http.Redirect(w, r, location, http.StatusFound)
+}
+
+func oidcUsername(claims *oidc.Claims) string {
+	name := claims.PreferredUsername
+	if name == "" {
+		name = strings.SplitN(claims.Email, "@", 2)[0]
+	}
+
+	name = usernameInvalidChars.ReplaceAllString(name, "")
+	if len(name) > maxUsernameLen {
+		name = name[:maxUsernameLen]
+	}
+	return name
+}
@@ -0,0 +1,65 @@
+package user
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/dabbertorres/web-srv-base/audit"
+	"github.com/dabbertorres/web-srv-base/db"
+	"github.com/dabbertorres/web-srv-base/dialogue"
+	"github.com/dabbertorres/web-srv-base/logme"
+	"github.com/dabbertorres/web-srv-base/model"
+)
+
+const (
+	loginHistoryLimit = 50
+)
+
+// Logins returns the user's most recent logins, and failed attempts to.
+func Logins(w http.ResponseWriter, r *http.Request) {
+	_, username := dialogue.IsLoggedIn(r)
+
+	results, err := db.AuditEvents(r.Context(), &db.AuditFilter{
+		Target:  username,
+		Actions: []string{audit.Login, audit.LoginFailed},
+		Limit:   loginHistoryLimit,
+	}, time.UTC)
+	if err != nil {
+		model.Log(logme.LevelError, r, err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(results)
+	if err != nil {
+		model.Log(logme.LevelError, r, err.Error())
+	}
+}
+
+// Sessions returns the sessions the user is logged in to.
+func Sessions(w http.ResponseWriter, r *http.Request) {
+	_, username := dialogue.IsLoggedIn(r)
+
+	sessions, err := dialogue.Sessions(r, username)
+	if err != nil {
+		model.Log(logme.LevelError, r, err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// admins acting as the user aren't the user's own sessions
+	results := make([]dialogue.SessionInfo, 0, len(sessions))
+	for _, s := range sessions {
+		if s.Impersonator == "" {
+			results = append(results, s)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(results)
+	if err != nil {
+		model.Log(logme.LevelError, r, err.Error())
+	}
+}
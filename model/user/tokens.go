@@ -0,0 +1,147 @@
+package user
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+
+	"github.com/dabbertorres/web-srv-base/audit"
+	"github.com/dabbertorres/web-srv-base/db"
+	"github.com/dabbertorres/web-srv-base/dialogue"
+	"github.com/dabbertorres/web-srv-base/logme"
+	"github.com/dabbertorres/web-srv-base/model"
+	"github.com/dabbertorres/web-srv-base/perms"
+	"github.com/dabbertorres/web-srv-base/tokens"
+)
+
+const (
+	maxTokenNameLen = 64
+)
+
+type newTokenResponse struct {
+	db.Token
+	Secret string `json:"token"`
+}
+
+func Tokens(w http.ResponseWriter, r *http.Request) {
+	_, username := dialogue.IsLoggedIn(r)
+
+	results, err := db.TokensOf(r.Context(), username)
+	if err != nil {
+		model.Log(logme.LevelError, r, err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(results)
+	if err != nil {
+		model.Log(logme.LevelError, r, err.Error())
+	}
+}
+
+// TokenNew creates a token for the logged in user. Not while impersonating, as it would outlive the impersonation.
+func TokenNew(w http.ResponseWriter, r *http.Request) {
+	if refuseImpersonator(w, r) {
+		return
+	}
+
+	_, username := dialogue.IsLoggedIn(r)
+
+	err := r.ParseForm()
+	if err != nil {
+		model.Log(logme.LevelWarn, r, err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	var (
+		name   = r.Form.Get("name")
+		scopes = r.Form["scope"]
+	)
+
+	if name == "" || len(name) > maxTokenNameLen || len(scopes) == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	for _, s := range scopes {
+		exists, permission := tokens.Valid(s)
+		if !exists {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		has, err := perms.Has(r, permission)
+		if err != nil {
+			model.Log(logme.LevelError, r, err.Error())
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if !has {
+			model.Log(logme.LevelWarn, r, "user lacks permission for requested token scope: "+s)
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+	}
+
+	token, secret, err := db.TokenNew(r.Context(), username, name, scopes)
+	if err != nil {
+		model.Log(logme.LevelError, r, err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	audit.Record(r, audit.TokenCreate, username, audit.Meta{"id": token.ID, "name": name, "scopes": scopes})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	err = json.NewEncoder(w).Encode(&newTokenResponse{Token: token, Secret: secret})
+	if err != nil {
+		model.Log(logme.LevelError, r, err.Error())
+	}
+}
+
+// TokenRevoke revokes one of the logged in user's tokens. Not while impersonating, as it's not the admin's to revoke.
+func TokenRevoke(w http.ResponseWriter, r *http.Request) {
+	if refuseImpersonator(w, r) {
+		return
+	}
+
+	_, username := dialogue.IsLoggedIn(r)
+
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	err = db.TokenRevoke(r.Context(), username, id)
+	switch err {
+	case nil:
+		audit.Record(r, audit.TokenRevoke, username, audit.Meta{"id": id})
+		w.WriteHeader(http.StatusNoContent)
+
+	case db.ErrTokenNotExist:
+		w.WriteHeader(http.StatusNotFound)
+
+	default:
+		model.Log(logme.LevelError, r, err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// refuseImpersonator responds 403, and returns true, if the session is impersonating its user.
+func refuseImpersonator(w http.ResponseWriter, r *http.Request) bool {
+	impersonating, impersonator := dialogue.Impersonator(r)
+	if !impersonating {
+		return false
+	}
+
+	model.Log(logme.LevelWarn, r, "'"+impersonator+"' may not manage tokens while impersonating")
+	audit.Record(r, audit.AccessDenied, r.URL.Path, audit.Meta{"reason": "impersonating"})
+	w.WriteHeader(http.StatusForbidden)
+	return true
+}
@@ -4,9 +4,11 @@ import (
 	"fmt"
 	"net/http"
 
+	"github.com/dabbertorres/web-srv-base/audit"
 	"github.com/dabbertorres/web-srv-base/db"
 	"github.com/dabbertorres/web-srv-base/dialogue"
 	"github.com/dabbertorres/web-srv-base/logme"
+	"github.com/dabbertorres/web-srv-base/model"
 )
 
 func Middleware(next http.Handler) http.Handler {
@@ -15,7 +17,7 @@ func Middleware(next http.Handler) http.Handler {
 		if !loggedIn {
 			err := dialogue.SaveLocation(r)
 			if err != nil {
-				logme.Warn().Println("saving location for session:", err)
+				model.Log(logme.LevelWarn, r, "saving location for session: "+err.Error())
 			}
 
 			http.Redirect(w, r, "/login", http.StatusFound)
@@ -30,7 +32,7 @@ func New(w http.ResponseWriter, r *http.Request) {
 	err := r.ParseForm()
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
-		logme.Err().Println("parsing user login form:", err)
+		model.Log(logme.LevelError, r, "parsing user login form: "+err.Error())
 		// TODO nicer "failed account creation"
 		return
 	}
@@ -47,14 +49,84 @@ func New(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = db.UserNew(r.Context(), username, password, false)
+	err = db.UserNew(r.Context(), username, password)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
-		logme.Err().Println("creating new user:", err)
+		model.Log(logme.LevelError, r, "creating new user: "+err.Error())
 		return
 	}
 
+	audit.Record(r, audit.UserCreate, username, nil)
+
 	// TODO email confirmation of account and all that fun stuff
 
 	fmt.Fprintf(w, "Welcome, %s!", username)
 }
+
+// StopImpersonating returns an admin's session to themselves, after they had impersonated a user.
+func StopImpersonating(w http.ResponseWriter, r *http.Request) {
+	user, err := dialogue.StopImpersonating(r)
+	switch err {
+	case nil:
+
+	case dialogue.ErrNotImpersonating:
+		w.WriteHeader(http.StatusBadRequest)
+		return
+
+	default:
+		model.Log(logme.LevelError, r, "stopping impersonation: "+err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	_, admin := dialogue.IsLoggedIn(r)
+	model.Log(logme.LevelInfo, r, "'"+admin+"' stopped impersonating '"+user+"'")
+	audit.Record(r, audit.ImpersonateStop, user, nil)
+
+	http.Redirect(w, r, "/admin/", http.StatusSeeOther)
+}
+
+// ChangePassword changes the logged in user's password, if they give their current one.
+func ChangePassword(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		model.Log(logme.LevelError, r, "parsing change password form: "+err.Error())
+		return
+	}
+
+	var (
+		_, username     = dialogue.IsLoggedIn(r)
+		current         = r.Form.Get("current")
+		password        = r.Form.Get("password")
+		passwordConfirm = r.Form.Get("passwordConfirm")
+	)
+
+	if password == "" || password != passwordConfirm {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	can, err := db.UserCanLogin(r.Context(), username, current)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		model.Log(logme.LevelError, r, "checking current password: "+err.Error())
+		return
+	}
+
+	if !can {
+		audit.Record(r, audit.PasswordChange, username, audit.Meta{"success": false})
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	err = db.UserChangePassword(r.Context(), username, password)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		model.Log(logme.LevelError, r, "changing password: "+err.Error())
+		return
+	}
+
+	audit.Record(r, audit.PasswordChange, username, audit.Meta{"success": true})
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,279 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrNotOpen          = errors.New("oidc provider is not configured")
+	ErrAlreadyOpen      = errors.New("oidc provider is already configured")
+	ErrIssuerMismatch   = errors.New("discovered issuer does not match configured issuer")
+	ErrNoIDToken        = errors.New("token response did not contain an id_token")
+	ErrUnsupportedFlow  = errors.New("provider does not support the authorization code flow with S256 PKCE")
+	ErrTokenEndpointErr = errors.New("token endpoint returned an error")
+)
+
+const (
+	discoveryPath = "/.well-known/openid-configuration"
+	httpTimeout   = 10 * time.Second
+	randBytes     = 32
+)
+
+type discovery struct {
+	Issuer                        string   `json:"issuer"`
+	AuthorizationEndpoint         string   `json:"authorization_endpoint"`
+	TokenEndpoint                 string   `json:"token_endpoint"`
+	JWKSURI                       string   `json:"jwks_uri"`
+	ResponseTypesSupported        []string `json:"response_types_supported"`
+	CodeChallengeMethodsSupported []string `json:"code_challenge_methods_supported"`
+}
+
+type tokenResponse struct {
+	AccessToken      string `json:"access_token"`
+	TokenType        string `json:"token_type"`
+	IDToken          string `json:"id_token"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+var (
+	mutex sync.RWMutex
+
+	client = &http.Client{Timeout: httpTimeout}
+
+	issuer       string
+	clientID     string
+	clientSecret string
+	redirectURL  string
+	scopes       []string
+
+	endpoints discovery
+	keys      *keySet
+)
+
+// Open configures the relying party by fetching the issuer's discovery document.
+// scopes always includes "openid", whether or not it is given.
+func Open(ctx context.Context, issuerURL, id, secret, redirect string, scope []string) (err error) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if issuer != "" {
+		return ErrAlreadyOpen
+	}
+
+	issuerURL = strings.TrimSuffix(issuerURL, "/")
+
+	var disc discovery
+	err = getJSON(ctx, issuerURL+discoveryPath, &disc)
+	if err != nil {
+		return fmt.Errorf("discovery: %v", err)
+	}
+
+	if strings.TrimSuffix(disc.Issuer, "/") != issuerURL {
+		return ErrIssuerMismatch
+	}
+
+	if !contains(disc.ResponseTypesSupported, "code") {
+		return ErrUnsupportedFlow
+	}
+
+	// the spec allows omitting code_challenge_methods_supported; assume S256 is supported if so
+	if len(disc.CodeChallengeMethodsSupported) != 0 && !contains(disc.CodeChallengeMethodsSupported, "S256") {
+		return ErrUnsupportedFlow
+	}
+
+	if !contains(scope, "openid") {
+		scope = append([]string{"openid"}, scope...)
+	}
+
+	issuer = disc.Issuer
+	clientID = id
+	clientSecret = secret
+	redirectURL = redirect
+	scopes = scope
+	endpoints = disc
+	keys = newKeySet(disc.JWKSURI)
+	return nil
+}
+
+// Enabled reports whether Open has successfully configured a provider.
+func Enabled() bool {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	return issuer != ""
+}
+
+// Issuer returns the configured provider's issuer identifier.
+func Issuer() string {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	return issuer
+}
+
+// NewChallenge generates fresh state, nonce, and PKCE verifier values for a single authorization request.
+// They should be stored server side (ie: in the session) until the provider redirects back.
+func NewChallenge() (state, nonce, verifier string, err error) {
+	if state, err = randString(); err != nil {
+		return
+	}
+	if nonce, err = randString(); err != nil {
+		return
+	}
+	verifier, err = randString()
+	return
+}
+
+// ValidState reports whether the state the provider redirected back with matches the stored one.
+// An empty stored state never matches, since it means no authorization request was started.
+func ValidState(stored, received string) bool {
+	return stored != "" && subtle.ConstantTimeCompare([]byte(stored), []byte(received)) == 1
+}
+
+// AuthURL returns the provider URL to redirect the user agent to, to begin authentication.
+func AuthURL(state, nonce, verifier string) (string, error) {
+	mutex.RLock()
+	defer mutex.RUnlock()
+
+	if issuer == "" {
+		return "", ErrNotOpen
+	}
+
+	challenge := sha256.Sum256([]byte(verifier))
+
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {clientID},
+		"redirect_uri":          {redirectURL},
+		"scope":                 {strings.Join(scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+
+	authURL, err := url.Parse(endpoints.AuthorizationEndpoint)
+	if err != nil {
+		return "", err
+	}
+
+	// keep any query parameters the provider put in its endpoint
+	existing := authURL.Query()
+	for k, v := range query {
+		existing[k] = v
+	}
+	authURL.RawQuery = existing.Encode()
+
+	return authURL.String(), nil
+}
+
+// Exchange redeems an authorization code, then verifies and returns the claims of the resulting ID token.
+func Exchange(ctx context.Context, code, verifier, nonce string) (claims Claims, err error) {
+	mutex.RLock()
+	var (
+		tokenURL = endpoints.TokenEndpoint
+		id       = clientID
+		secret   = clientSecret
+		redirect = redirectURL
+		ks       = keys
+		iss      = issuer
+	)
+	mutex.RUnlock()
+
+	if iss == "" {
+		err = ErrNotOpen
+		return
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirect},
+		"code_verifier": {verifier},
+	}
+
+	req, err := http.NewRequest(http.MethodPost, tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(id), url.QueryEscape(secret))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+
+	var tokens tokenResponse
+	err = json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&tokens)
+	if err != nil {
+		err = fmt.Errorf("decoding token response (status %d): %v", resp.StatusCode, err)
+		return
+	}
+
+	if resp.StatusCode != http.StatusOK || tokens.Error != "" {
+		err = fmt.Errorf("%v: %s %s", ErrTokenEndpointErr, tokens.Error, tokens.ErrorDescription)
+		return
+	}
+
+	if tokens.IDToken == "" {
+		err = ErrNoIDToken
+		return
+	}
+
+	return verifyIDToken(ctx, ks, tokens.IDToken, iss, id, nonce, time.Now())
+}
+
+func getJSON(ctx context.Context, url string, v interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
+
+func randString() (string, error) {
+	buf := make([]byte, randBytes)
+	_, err := io.ReadFull(rand.Reader, buf)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,466 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	testClientID     = "test-client"
+	testClientSecret = "test-secret"
+	testRedirect     = "https://example.com/login/oidc/callback"
+	testCode         = "test-code"
+)
+
+// fakeProvider is a minimal OpenID Connect provider, serving discovery, a key set, and a token endpoint.
+type fakeProvider struct {
+	*httptest.Server
+
+	rsaKey *rsa.PrivateKey
+	ecKey  *ecdsa.PrivateKey
+
+	mutex sync.Mutex
+	// discovery is served as is, after being filled in with the server's URLs
+	discovery discovery
+	// challenge is the PKCE code challenge the authorization request was made with
+	challenge string
+	// token builds the ID token returned for a successful code exchange
+	token func(p *fakeProvider) string
+	// keyFetches counts requests for the key set
+	keyFetches int
+}
+
+func newFakeProvider(t *testing.T) *fakeProvider {
+	t.Helper()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := &fakeProvider{
+		rsaKey: rsaKey,
+		ecKey:  ecKey,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(discoveryPath, p.serveDiscovery)
+	mux.HandleFunc("/jwks", p.serveKeys)
+	mux.HandleFunc("/token", p.serveToken)
+	p.Server = httptest.NewServer(mux)
+
+	p.discovery = discovery{
+		Issuer:                        p.URL,
+		AuthorizationEndpoint:         p.URL + "/authorize?prompt=login",
+		TokenEndpoint:                 p.URL + "/token",
+		JWKSURI:                       p.URL + "/jwks",
+		ResponseTypesSupported:        []string{"code", "id_token"},
+		CodeChallengeMethodsSupported: []string{"plain", "S256"},
+	}
+	p.token = func(p *fakeProvider) string {
+		return p.sign("RS256", "rsa", p.claims())
+	}
+
+	t.Cleanup(p.Close)
+	return p
+}
+
+func (p *fakeProvider) serveDiscovery(w http.ResponseWriter, r *http.Request) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	json.NewEncoder(w).Encode(p.discovery)
+}
+
+func (p *fakeProvider) serveKeys(w http.ResponseWriter, r *http.Request) {
+	p.mutex.Lock()
+	p.keyFetches++
+	p.mutex.Unlock()
+
+	size := (p.ecKey.Curve.Params().BitSize + 7) / 8
+
+	json.NewEncoder(w).Encode(map[string][]jsonWebKey{
+		"keys": {
+			{
+				Kty: "RSA",
+				Kid: "rsa",
+				Use: "sig",
+				N:   encodeBigInt(p.rsaKey.N, 0),
+				E:   encodeBigInt(big.NewInt(int64(p.rsaKey.E)), 0),
+			},
+			{
+				Kty: "EC",
+				Kid: "ec",
+				Crv: "P-256",
+				X:   encodeBigInt(p.ecKey.X, size),
+				Y:   encodeBigInt(p.ecKey.Y, size),
+			},
+			{
+				// encryption keys must be ignored
+				Kty: "RSA",
+				Kid: "enc",
+				Use: "enc",
+				N:   encodeBigInt(p.rsaKey.N, 0),
+				E:   encodeBigInt(big.NewInt(int64(p.rsaKey.E)), 0),
+			},
+		},
+	})
+}
+
+func (p *fakeProvider) serveToken(w http.ResponseWriter, r *http.Request) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+
+	id, secret, ok := r.BasicAuth()
+	if !ok || id != testClientID || secret != testClientSecret {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(tokenResponse{Error: "invalid_client"})
+		return
+	}
+
+	verifier := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+
+	switch {
+	case r.Method != http.MethodPost,
+		r.PostFormValue("grant_type") != "authorization_code",
+		r.PostFormValue("code") != testCode,
+		r.PostFormValue("redirect_uri") != testRedirect,
+		base64.RawURLEncoding.EncodeToString(verifier[:]) != p.challenge:
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(tokenResponse{Error: "invalid_grant"})
+		return
+	}
+
+	json.NewEncoder(w).Encode(tokenResponse{
+		AccessToken: "access",
+		TokenType:   "Bearer",
+		IDToken:     p.token(p),
+	})
+}
+
+// claims returns a valid set of claims for the test client, with the nonce "test-nonce".
+func (p *fakeProvider) claims() map[string]interface{} {
+	now := time.Now()
+	return map[string]interface{}{
+		"iss":            p.URL,
+		"sub":            "1234",
+		"aud":            testClientID,
+		"exp":            now.Add(time.Hour).Unix(),
+		"iat":            now.Unix(),
+		"nonce":          "test-nonce",
+		"email":          "user@example.com",
+		"email_verified": true,
+	}
+}
+
+func (p *fakeProvider) fetches() int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.keyFetches
+}
+
+// sign builds a compact serialized JWT, signed with the provider's key for alg.
+func (p *fakeProvider) sign(alg, kid string, claims map[string]interface{}) string {
+	signed := encodeToken(alg, kid, claims)
+	digest := sha256.Sum256([]byte(signed))
+
+	var sig []byte
+	switch alg {
+	case "RS256":
+		sig, _ = rsa.SignPKCS1v15(rand.Reader, p.rsaKey, crypto.SHA256, digest[:])
+
+	case "PS256":
+		sig, _ = rsa.SignPSS(rand.Reader, p.rsaKey, crypto.SHA256, digest[:], nil)
+
+	case "ES256":
+		r, s, _ := ecdsa.Sign(rand.Reader, p.ecKey, digest[:])
+		sig = make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+	}
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+// encodeToken returns the signed portion of a JWT: its header and claims.
+func encodeToken(alg, kid string, claims map[string]interface{}) string {
+	header, _ := json.Marshal(tokenHeader{Alg: alg, Kid: kid})
+	payload, _ := json.Marshal(claims)
+	return base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+}
+
+func encodeBigInt(n *big.Int, size int) string {
+	buf := n.Bytes()
+	if len(buf) < size {
+		buf = append(make([]byte, size-len(buf)), buf...)
+	}
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
+
+// openFake configures the package against p, and resets it when the test ends.
+func openFake(t *testing.T, p *fakeProvider) {
+	t.Helper()
+
+	err := Open(context.Background(), p.URL+"/", testClientID, testClientSecret, testRedirect, []string{"email"})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	t.Cleanup(reset)
+}
+
+func reset() {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	issuer = ""
+	clientID = ""
+	clientSecret = ""
+	redirectURL = ""
+	scopes = nil
+	endpoints = discovery{}
+	keys = nil
+}
+
+func TestOpen(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(d *discovery)
+		want   error
+	}{
+		{
+			name:   "valid",
+			modify: func(d *discovery) {},
+		},
+		{
+			name:   "omitted challenge methods",
+			modify: func(d *discovery) { d.CodeChallengeMethodsSupported = nil },
+		},
+		{
+			name:   "issuer mismatch",
+			modify: func(d *discovery) { d.Issuer = "https://evil.example.com" },
+			want:   ErrIssuerMismatch,
+		},
+		{
+			name:   "no code flow",
+			modify: func(d *discovery) { d.ResponseTypesSupported = []string{"id_token"} },
+			want:   ErrUnsupportedFlow,
+		},
+		{
+			name:   "no S256",
+			modify: func(d *discovery) { d.CodeChallengeMethodsSupported = []string{"plain"} },
+			want:   ErrUnsupportedFlow,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newFakeProvider(t)
+			tt.modify(&p.discovery)
+
+			err := Open(context.Background(), p.URL, testClientID, testClientSecret, testRedirect, nil)
+			defer reset()
+
+			if err != tt.want {
+				t.Fatalf("Open() = %v, want %v", err, tt.want)
+			}
+			if Enabled() != (tt.want == nil) {
+				t.Fatalf("Enabled() = %v after Open() = %v", Enabled(), err)
+			}
+		})
+	}
+}
+
+func TestOpenTwice(t *testing.T) {
+	p := newFakeProvider(t)
+	openFake(t, p)
+
+	err := Open(context.Background(), p.URL, testClientID, testClientSecret, testRedirect, nil)
+	if err != ErrAlreadyOpen {
+		t.Fatalf("second Open() = %v, want %v", err, ErrAlreadyOpen)
+	}
+}
+
+func TestAuthURL(t *testing.T) {
+	_, err := AuthURL("state", "nonce", "verifier")
+	if err != ErrNotOpen {
+		t.Fatalf("AuthURL() before Open = %v, want %v", err, ErrNotOpen)
+	}
+
+	p := newFakeProvider(t)
+	openFake(t, p)
+
+	state, nonce, verifier, err := NewChallenge()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	raw, err := AuthURL(state, nonce, verifier)
+	if err != nil {
+		t.Fatalf("AuthURL: %v", err)
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := u.Scheme + "://" + u.Host + u.Path; got != p.URL+"/authorize" {
+		t.Errorf("endpoint = %s, want %s", got, p.URL+"/authorize")
+	}
+
+	challenge := sha256.Sum256([]byte(verifier))
+
+	want := map[string]string{
+		"response_type":         "code",
+		"client_id":             testClientID,
+		"redirect_uri":          testRedirect,
+		"scope":                 "openid email",
+		"state":                 state,
+		"nonce":                 nonce,
+		"code_challenge":        base64.RawURLEncoding.EncodeToString(challenge[:]),
+		"code_challenge_method": "S256",
+		"prompt":                "login",
+	}
+
+	query := u.Query()
+	for k, v := range want {
+		if got := query.Get(k); got != v {
+			t.Errorf("%s = %q, want %q", k, got, v)
+		}
+	}
+
+	if query.Get("code_verifier") != "" || strings.Contains(raw, verifier) {
+		t.Error("auth url leaks the code verifier")
+	}
+}
+
+func TestNewChallenge(t *testing.T) {
+	state, nonce, verifier, err := NewChallenge()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if state == nonce || state == verifier || nonce == verifier {
+		t.Error("challenge values are not independent")
+	}
+
+	// RFC 7636 requires verifiers of 43 to 128 characters
+	if len(verifier) < 43 || len(verifier) > 128 {
+		t.Errorf("verifier is %d characters", len(verifier))
+	}
+
+	state2, _, _, err := NewChallenge()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state == state2 {
+		t.Error("NewChallenge repeated a state")
+	}
+}
+
+func TestValidState(t *testing.T) {
+	tests := []struct {
+		stored, received string
+		want             bool
+	}{
+		{"abc", "abc", true},
+		{"abc", "abd", false},
+		{"abc", "", false},
+		{"abc", "abcd", false},
+		{"", "", false},
+	}
+
+	for _, tt := range tests {
+		if got := ValidState(tt.stored, tt.received); got != tt.want {
+			t.Errorf("ValidState(%q, %q) = %v, want %v", tt.stored, tt.received, got, tt.want)
+		}
+	}
+}
+
+func TestExchange(t *testing.T) {
+	ctx := context.Background()
+
+	_, err := Exchange(ctx, testCode, "verifier", "test-nonce")
+	if err != ErrNotOpen {
+		t.Fatalf("Exchange() before Open = %v, want %v", err, ErrNotOpen)
+	}
+
+	p := newFakeProvider(t)
+	openFake(t, p)
+
+	_, _, verifier, err := NewChallenge()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	raw, err := AuthURL("state", "test-nonce", verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse(raw)
+	p.challenge = u.Query().Get("code_challenge")
+
+	t.Run("valid", func(t *testing.T) {
+		claims, err := Exchange(ctx, testCode, verifier, "test-nonce")
+		if err != nil {
+			t.Fatalf("Exchange: %v", err)
+		}
+		if claims.Subject != "1234" || claims.Email != "user@example.com" || !claims.EmailVerified {
+			t.Errorf("unexpected claims: %+v", claims)
+		}
+	})
+
+	t.Run("wrong verifier", func(t *testing.T) {
+		_, err := Exchange(ctx, testCode, verifier+"x", "test-nonce")
+		if err == nil || !strings.HasPrefix(err.Error(), ErrTokenEndpointErr.Error()) {
+			t.Fatalf("Exchange() = %v, want %v", err, ErrTokenEndpointErr)
+		}
+	})
+
+	t.Run("wrong code", func(t *testing.T) {
+		_, err := Exchange(ctx, "other", verifier, "test-nonce")
+		if err == nil || !strings.Contains(err.Error(), "invalid_grant") {
+			t.Fatalf("Exchange() = %v, want invalid_grant", err)
+		}
+	})
+
+	t.Run("wrong nonce", func(t *testing.T) {
+		_, err := Exchange(ctx, testCode, verifier, "other-nonce")
+		if err != ErrInvalidClaims {
+			t.Fatalf("Exchange() = %v, want %v", err, ErrInvalidClaims)
+		}
+	})
+
+	t.Run("no id token", func(t *testing.T) {
+		p.token = func(p *fakeProvider) string { return "" }
+		defer func() {
+			p.token = func(p *fakeProvider) string { return p.sign("RS256", "rsa", p.claims()) }
+		}()
+
+		_, err := Exchange(ctx, testCode, verifier, "test-nonce")
+		if err != ErrNoIDToken {
+			t.Fatalf("Exchange() = %v, want %v", err, ErrNoIDToken)
+		}
+	})
+}
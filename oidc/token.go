@@ -0,0 +1,200 @@
+package oidc
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"strings"
+	"time"
+)
+
+var (
+	ErrMalformedToken   = errors.New("malformed id token")
+	ErrUnsupportedAlg   = errors.New("unsupported id token signing algorithm")
+	ErrInvalidSignature = errors.New("invalid id token signature")
+	ErrInvalidClaims    = errors.New("invalid id token claims")
+)
+
+const (
+	// tolerated clock difference between us and the provider
+	clockSkew = time.Minute
+)
+
+// Claims are the ID token claims the server cares about.
+type Claims struct {
+	Issuer            string   `json:"iss"`
+	Subject           string   `json:"sub"`
+	Audience          audience `json:"aud"`
+	AuthorizedParty   string   `json:"azp"`
+	Expiry            int64    `json:"exp"`
+	IssuedAt          int64    `json:"iat"`
+	Nonce             string   `json:"nonce"`
+	Email             string   `json:"email"`
+	EmailVerified     bool     `json:"email_verified"`
+	Name              string   `json:"name"`
+	PreferredUsername string   `json:"preferred_username"`
+}
+
+// the "aud" claim may either be a single string, or an array of strings
+type audience []string
+
+func (a *audience) UnmarshalJSON(buf []byte) error {
+	if bytes.HasPrefix(bytes.TrimSpace(buf), []byte{'['}) {
+		return json.Unmarshal(buf, (*[]string)(a))
+	}
+
+	var s string
+	err := json.Unmarshal(buf, &s)
+	if err != nil {
+		return err
+	}
+	*a = audience{s}
+	return nil
+}
+
+type tokenHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+func verifyIDToken(ctx context.Context, ks *keySet, token, iss, clientID, nonce string, now time.Time) (claims Claims, err error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		err = ErrMalformedToken
+		return
+	}
+
+	var header tokenHeader
+	err = decodeSegment(parts[0], &header)
+	if err != nil {
+		return
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		err = ErrMalformedToken
+		return
+	}
+
+	key, err := ks.get(ctx, header.Kid)
+	if err != nil {
+		return
+	}
+
+	err = verifySignature(header.Alg, key, []byte(parts[0]+"."+parts[1]), sig)
+	if err != nil {
+		return
+	}
+
+	err = decodeSegment(parts[1], &claims)
+	if err != nil {
+		return
+	}
+
+	switch {
+	case claims.Issuer != iss,
+		claims.Subject == "",
+		!contains(claims.Audience, clientID),
+		len(claims.Audience) > 1 && claims.AuthorizedParty != clientID,
+		claims.Nonce != nonce,
+		now.After(time.Unix(claims.Expiry, 0).Add(clockSkew)),
+		now.Add(clockSkew).Before(time.Unix(claims.IssuedAt, 0)):
+		err = ErrInvalidClaims
+	}
+
+	return
+}
+
+func verifySignature(alg string, key crypto.PublicKey, signed, sig []byte) error {
+	// all supported algorithms are of the form <family><hash size>, ie: RS256
+	if len(alg) != 5 {
+		return ErrUnsupportedAlg
+	}
+
+	var hash crypto.Hash
+	switch alg[2:] {
+	case "256":
+		hash = crypto.SHA256
+	case "384":
+		hash = crypto.SHA384
+	case "512":
+		hash = crypto.SHA512
+	default:
+		return ErrUnsupportedAlg
+	}
+
+	var digest []byte
+	switch hash {
+	case crypto.SHA256:
+		sum := sha256.Sum256(signed)
+		digest = sum[:]
+	case crypto.SHA384:
+		sum := sha512.Sum384(signed)
+		digest = sum[:]
+	case crypto.SHA512:
+		sum := sha512.Sum512(signed)
+		digest = sum[:]
+	}
+
+	switch alg[:2] {
+	case "RS":
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return ErrUnsupportedAlg
+		}
+		if rsa.VerifyPKCS1v15(pub, hash, digest, sig) != nil {
+			return ErrInvalidSignature
+		}
+
+	case "PS":
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return ErrUnsupportedAlg
+		}
+		if rsa.VerifyPSS(pub, hash, digest, sig, nil) != nil {
+			return ErrInvalidSignature
+		}
+
+	case "ES":
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return ErrUnsupportedAlg
+		}
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		if len(sig) != 2*size {
+			return ErrInvalidSignature
+		}
+		r := new(big.Int).SetBytes(sig[:size])
+		s := new(big.Int).SetBytes(sig[size:])
+		if !ecdsa.Verify(pub, digest, r, s) {
+			return ErrInvalidSignature
+		}
+
+	default:
+		// notably, this rejects "none" and the HMAC algorithms
+		return ErrUnsupportedAlg
+	}
+
+	return nil
+}
+
+func decodeSegment(seg string, v interface{}) error {
+	buf, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return ErrMalformedToken
+	}
+
+	err = json.Unmarshal(buf, v)
+	if err != nil {
+		return ErrMalformedToken
+	}
+	return nil
+}
@@ -0,0 +1,205 @@
+package oidc
+
+import (
+	"context"
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerifyIDToken(t *testing.T) {
+	p := newFakeProvider(t)
+	other := newFakeProvider(t)
+
+	now := time.Now()
+
+	// with modifies a valid set of claims
+	with := func(modify func(c map[string]interface{})) map[string]interface{} {
+		claims := p.claims()
+		modify(claims)
+		return claims
+	}
+	valid := p.claims()
+
+	tests := []struct {
+		name  string
+		token string
+		want  error
+	}{
+		{
+			name:  "RS256",
+			token: p.sign("RS256", "rsa", valid),
+		},
+		{
+			name:  "PS256",
+			token: p.sign("PS256", "rsa", valid),
+		},
+		{
+			name:  "ES256",
+			token: p.sign("ES256", "ec", valid),
+		},
+		{
+			name:  "audience list with azp",
+			token: p.sign("RS256", "rsa", with(func(c map[string]interface{}) { c["aud"] = []string{"other", testClientID}; c["azp"] = testClientID })),
+		},
+		{
+			name:  "expired within skew",
+			token: p.sign("RS256", "rsa", with(func(c map[string]interface{}) { c["exp"] = now.Add(-clockSkew / 2).Unix() })),
+		},
+		{
+			name:  "signed by another key",
+			token: other.sign("RS256", "rsa", valid),
+			want:  ErrInvalidSignature,
+		},
+		{
+			name:  "key type does not match alg",
+			token: p.sign("ES256", "rsa", valid),
+			want:  ErrUnsupportedAlg,
+		},
+		{
+			name:  "unknown key",
+			token: p.sign("RS256", "missing", valid),
+			want:  ErrUnknownKey,
+		},
+		{
+			name:  "encryption key",
+			token: p.sign("RS256", "enc", valid),
+			want:  ErrUnknownKey,
+		},
+		{
+			name:  "alg none",
+			token: unsigned("none", valid),
+			want:  ErrUnsupportedAlg,
+		},
+		{
+			name:  "alg HS256",
+			token: unsigned("HS256", valid),
+			want:  ErrUnsupportedAlg,
+		},
+		{
+			name:  "wrong issuer",
+			token: p.sign("RS256", "rsa", with(func(c map[string]interface{}) { c["iss"] = other.URL })),
+			want:  ErrInvalidClaims,
+		},
+		{
+			name:  "wrong audience",
+			token: p.sign("RS256", "rsa", with(func(c map[string]interface{}) { c["aud"] = "other" })),
+			want:  ErrInvalidClaims,
+		},
+		{
+			name:  "audience list without azp",
+			token: p.sign("RS256", "rsa", with(func(c map[string]interface{}) { c["aud"] = []string{"other", testClientID} })),
+			want:  ErrInvalidClaims,
+		},
+		{
+			name:  "no subject",
+			token: p.sign("RS256", "rsa", with(func(c map[string]interface{}) { delete(c, "sub") })),
+			want:  ErrInvalidClaims,
+		},
+		{
+			name:  "wrong nonce",
+			token: p.sign("RS256", "rsa", with(func(c map[string]interface{}) { c["nonce"] = "other-nonce" })),
+			want:  ErrInvalidClaims,
+		},
+		{
+			name:  "expired",
+			token: p.sign("RS256", "rsa", with(func(c map[string]interface{}) { c["exp"] = now.Add(-2 * clockSkew).Unix() })),
+			want:  ErrInvalidClaims,
+		},
+		{
+			name:  "issued in the future",
+			token: p.sign("RS256", "rsa", with(func(c map[string]interface{}) { c["iat"] = now.Add(2 * clockSkew).Unix() })),
+			want:  ErrInvalidClaims,
+		},
+		{
+			name:  "tampered claims",
+			token: tamper(p.sign("RS256", "rsa", valid), with(func(c map[string]interface{}) { c["sub"] = "5678" })),
+			want:  ErrInvalidSignature,
+		},
+		{
+			name:  "two segments",
+			token: "a.b",
+			want:  ErrMalformedToken,
+		},
+		{
+			name:  "bad signature encoding",
+			token: p.sign("RS256", "rsa", valid) + "!",
+			want:  ErrMalformedToken,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ks := newKeySet(p.URL + "/jwks")
+
+			claims, err := verifyIDToken(context.Background(), ks, tt.token, p.URL, testClientID, "test-nonce", now)
+			if err != tt.want {
+				t.Fatalf("verifyIDToken() = %v, want %v", err, tt.want)
+			}
+			if err == nil && claims.Subject != "1234" {
+				t.Fatalf("subject = %q, want %q", claims.Subject, "1234")
+			}
+		})
+	}
+}
+
+func TestKeySetRefreshLimit(t *testing.T) {
+	p := newFakeProvider(t)
+	ks := newKeySet(p.URL + "/jwks")
+	ctx := context.Background()
+
+	if _, err := ks.get(ctx, "rsa"); err != nil {
+		t.Fatalf("get: %v", err)
+	}
+
+	// an unknown key must not trigger another fetch so soon after the last one
+	for i := 0; i < 3; i++ {
+		if _, err := ks.get(ctx, "missing"); err != ErrUnknownKey {
+			t.Fatalf("get() = %v, want %v", err, ErrUnknownKey)
+		}
+	}
+
+	if p.fetches() != 1 {
+		t.Fatalf("key set fetched %d times, want 1", p.fetches())
+	}
+
+	// once the limit has passed, an unknown key is looked for again, to pick up rotated keys
+	ks.lastRefresh = time.Now().Add(-minKeyRefresh)
+	if _, err := ks.get(ctx, "missing"); err != ErrUnknownKey {
+		t.Fatalf("get() = %v, want %v", err, ErrUnknownKey)
+	}
+
+	if p.fetches() != 2 {
+		t.Fatalf("key set fetched %d times, want 2", p.fetches())
+	}
+}
+
+// unsigned builds a token with an empty signature, claiming to use alg.
+func unsigned(alg string, claims map[string]interface{}) string {
+	return encodeToken(alg, "rsa", claims) + "."
+}
+
+// tamper replaces a signed token's claims, keeping its original signature.
+func tamper(token string, claims map[string]interface{}) string {
+	sig := token[strings.LastIndexByte(token, '.'):]
+	return encodeToken("RS256", "rsa", claims) + sig
+}
+
+func TestAudienceUnmarshal(t *testing.T) {
+	var claims Claims
+
+	if err := decodeSegment(base64.RawURLEncoding.EncodeToString([]byte(`{"aud":"one"}`)), &claims); err != nil {
+		t.Fatal(err)
+	}
+	if len(claims.Audience) != 1 || claims.Audience[0] != "one" {
+		t.Errorf("single audience = %v", claims.Audience)
+	}
+
+	if err := decodeSegment(base64.RawURLEncoding.EncodeToString([]byte(`{"aud":["one","two"]}`)), &claims); err != nil {
+		t.Fatal(err)
+	}
+	if len(claims.Audience) != 2 || claims.Audience[1] != "two" {
+		t.Errorf("audience list = %v", claims.Audience)
+	}
+}
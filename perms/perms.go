@@ -0,0 +1,138 @@
+package perms
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/dabbertorres/web-srv-base/audit"
+	"github.com/dabbertorres/web-srv-base/db"
+	"github.com/dabbertorres/web-srv-base/dialogue"
+	"github.com/dabbertorres/web-srv-base/logme"
+)
+
+const (
+	VisitsView       = "visits.view"
+	UsersManage      = "users.manage"
+	UsersImpersonate = "users.impersonate"
+	SessionsRevoke   = "sessions.revoke"
+	AuditView        = "audit.view"
+)
+
+const (
+	// how long a session's cached permissions are trusted before reloading them from the db
+	cacheTTL = 5 * time.Minute
+)
+
+var (
+	All = []string{
+		VisitsView,
+		UsersManage,
+		UsersImpersonate,
+		SessionsRevoke,
+		AuditView,
+	}
+
+	logger = logme.For("perms")
+)
+
+// Valid reports whether perm is a known permission.
+func Valid(perm string) bool {
+	for _, p := range All {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// Of returns the permissions of the logged in user, from the session's cache if it is fresh enough.
+func Of(r *http.Request) (perms []string, err error) {
+	loggedIn, username := dialogue.IsLoggedIn(r)
+	if !loggedIn {
+		return
+	}
+
+	perms, cachedAt, err := dialogue.GetPermissions(r)
+	if err != nil {
+		return
+	}
+
+	if time.Since(cachedAt) < cacheTTL {
+		return
+	}
+
+	perms, err = db.UserPermissions(r.Context(), username)
+	if err != nil {
+		return
+	}
+
+	err = dialogue.SetPermissions(r, perms)
+	return
+}
+
+// Has reports whether the logged in user has all of perms.
+func Has(r *http.Request, perms ...string) (bool, error) {
+	have, err := Of(r)
+	if err != nil {
+		return false, err
+	}
+
+	return Subset(perms, have), nil
+}
+
+// RequirePermission returns a middleware that only allows through logged in users with all of perms.
+// Users that aren't logged in are redirected to the login page.
+func RequirePermission(perms ...string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			loggedIn, username := dialogue.IsLoggedIn(r)
+			if !loggedIn {
+				err := dialogue.SaveLocation(r)
+				if err != nil {
+					logger.Ctx(r.Context()).Warn("saving location for session", "err", err)
+				}
+
+				http.Redirect(w, r, "/login", http.StatusFound)
+				return
+			}
+
+			has, err := Has(r, perms...)
+			if err != nil {
+				logger.Ctx(r.Context()).Error("checking user permissions", "err", err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+
+			if !has {
+				logger.Ctx(r.Context()).Warn("denied access", "user", username, "uri", r.RequestURI, "requires", perms)
+				audit.Record(r, audit.AccessDenied, r.URL.Path, audit.Meta{"requires": perms})
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Subset reports whether every permission in perms is also in of.
+func Subset(perms, of []string) bool {
+	for _, p := range perms {
+		if !Contains(of, p) {
+			return false
+		}
+	}
+	return true
+}
+
+// Contains reports whether perm is in list.
+func Contains(list []string, perm string) bool {
+	for _, v := range list {
+		if v == perm {
+			return true
+		}
+	}
+	return false
+}
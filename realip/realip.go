@@ -0,0 +1,186 @@
+package realip
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+type ipCtxKey struct{}
+
+const (
+	Forwarded     = "Forwarded"
+	XForwardedFor = "X-Forwarded-For"
+	XRealIP       = "X-Real-IP"
+)
+
+var (
+	ErrUnknownHeader = errors.New("unknown proxy header")
+)
+
+var (
+	mutex   sync.RWMutex
+	trusted []*net.IPNet
+	header  = XForwardedFor
+)
+
+// SetTrustedProxies sets the networks (in CIDR notation) of proxies whose forwarding headers are believed.
+// Headers from any other peer are ignored, as they could be forged by the client.
+func SetTrustedProxies(cidrs []string) error {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, ipNet, err := net.ParseCIDR(strings.TrimSpace(cidr))
+		if err != nil {
+			return err
+		}
+		nets = append(nets, ipNet)
+	}
+
+	mutex.Lock()
+	trusted = nets
+	mutex.Unlock()
+	return nil
+}
+
+// SetProxyHeader sets the one header trusted proxies report the client's address in: Forwarded, X-Forwarded-For, or X-Real-IP.
+// No other header is read, as a client could send it along, and the proxy would pass it through untouched.
+func SetProxyHeader(name string) error {
+	name = strings.TrimSpace(name)
+
+	for _, h := range []string{Forwarded, XForwardedFor, XRealIP} {
+		if strings.EqualFold(name, h) {
+			mutex.Lock()
+			header = h
+			mutex.Unlock()
+			return nil
+		}
+	}
+
+	return ErrUnknownHeader
+}
+
+// Middleware determines the client's IP once per request, for IP and String.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := fromRequest(r)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), ipCtxKey{}, ip)))
+	})
+}
+
+// IP returns the client's address. It may be nil if the request didn't come over IP (ie: tests, unix sockets).
+func IP(r *http.Request) net.IP {
+	if ip, ok := r.Context().Value(ipCtxKey{}).(net.IP); ok {
+		return ip
+	}
+	return fromRequest(r)
+}
+
+// String returns the client's address as a string, or the raw remote address if it isn't an IP.
+func String(r *http.Request) string {
+	if ip := IP(r); ip != nil {
+		return ip.String()
+	}
+	return r.RemoteAddr
+}
+
+// fromRequest walks back along the chain of proxies, starting from the peer, until the first untrusted address.
+// Only the header set by SetProxyHeader is read.
+func fromRequest(r *http.Request) net.IP {
+	peer := parseAddr(r.RemoteAddr)
+	if peer == nil || !isTrusted(peer) {
+		return peer
+	}
+
+	mutex.RLock()
+	name := header
+	mutex.RUnlock()
+
+	var chain []net.IP
+	switch name {
+	case Forwarded:
+		chain = parseForwarded(r.Header.Values(Forwarded))
+	case XForwardedFor:
+		chain = parseXForwardedFor(r.Header.Values(XForwardedFor))
+	case XRealIP:
+		if ip := parseAddr(r.Header.Get(XRealIP)); ip != nil {
+			chain = []net.IP{ip}
+		}
+	}
+
+	client := peer
+	for i := len(chain) - 1; i >= 0; i-- {
+		// an unparseable hop can't be trusted, so stop at the last known one
+		if chain[i] == nil {
+			break
+		}
+
+		client = chain[i]
+		if !isTrusted(client) {
+			break
+		}
+	}
+
+	return client
+}
+
+// X-Forwarded-For: <client>, <proxy1>, <proxy2>
+// the header may be repeated, which is equivalent to joining them with commas
+func parseXForwardedFor(headers []string) (chain []net.IP) {
+	for _, h := range headers {
+		for _, hop := range strings.Split(h, ",") {
+			chain = append(chain, parseAddr(strings.TrimSpace(hop)))
+		}
+	}
+	return
+}
+
+// Forwarded: for=192.0.2.60;proto=http;by=203.0.113.43, for="[2001:db8:cafe::17]:4711"
+// see RFC 7239
+func parseForwarded(headers []string) (chain []net.IP) {
+	for _, h := range headers {
+		for _, element := range strings.Split(h, ",") {
+			var ip net.IP
+			for _, pair := range strings.Split(element, ";") {
+				kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+				if len(kv) == 2 && strings.EqualFold(kv[0], "for") {
+					ip = parseAddr(strings.Trim(kv[1], `"`))
+				}
+			}
+
+			// includes "unknown" and obfuscated identifiers, which are nil
+			chain = append(chain, ip)
+		}
+	}
+	return
+}
+
+// parses an address with or without a port, and with or without brackets around IPv6 addresses
+func parseAddr(addr string) net.IP {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+
+	addr = strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]")
+
+	// drop any IPv6 zone
+	if i := strings.IndexByte(addr, '%'); i >= 0 {
+		addr = addr[:i]
+	}
+
+	return net.ParseIP(addr)
+}
+
+func isTrusted(ip net.IP) bool {
+	mutex.RLock()
+	defer mutex.RUnlock()
+
+	for _, n := range trusted {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
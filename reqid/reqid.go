@@ -0,0 +1,74 @@
+package reqid
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"github.com/dabbertorres/web-srv-base/logme"
+)
+
+const (
+	Header = "X-Request-ID"
+
+	// longer IDs from clients are replaced, rather than written to every log line
+	maxLength = 128
+)
+
+type idCtxKey struct{}
+
+// Middleware gives each request an ID - the one it came with in the X-Request-ID header (ie: from a load balancer), or
+// a new one - and echoes it back in the response's header. Loggers from logme.Logger.Ctx add it, and the request's
+// route, to their records.
+// It must be installed on the router (not a subrouter) to cover every route.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(Header)
+		if !valid(id) {
+			id = newID()
+		}
+		w.Header().Set(Header, id)
+
+		ctx := context.WithValue(r.Context(), idCtxKey{}, id)
+
+		fields := []interface{}{"request", id}
+		if route := mux.CurrentRoute(r); route != nil {
+			if tmpl, err := route.GetPathTemplate(); err == nil {
+				fields = append(fields, "route", tmpl)
+			}
+		}
+		ctx = logme.NewContext(ctx, fields...)
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// Get returns r's ID, or "" if it hasn't been through Middleware.
+func Get(r *http.Request) string {
+	id, _ := r.Context().Value(idCtxKey{}).(string)
+	return id
+}
+
+func newID() string {
+	var buf [12]byte
+	rand.Read(buf[:])
+	return hex.EncodeToString(buf[:])
+}
+
+// valid reports whether id is safe to log and echo back: not too long, and only printable ASCII without spaces or quotes.
+func valid(id string) bool {
+	if id == "" || len(id) > maxLength {
+		return false
+	}
+
+	for i := 0; i < len(id); i++ {
+		c := id[i]
+		if c <= ' ' || c > '~' || c == '"' || c == '\\' {
+			return false
+		}
+	}
+	return true
+}
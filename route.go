@@ -11,11 +11,16 @@ import (
 
 	"github.com/dabbertorres/web-srv-base/db"
 	"github.com/dabbertorres/web-srv-base/dialogue"
-	"github.com/dabbertorres/web-srv-base/logme"
+	"github.com/dabbertorres/web-srv-base/metrics"
 	"github.com/dabbertorres/web-srv-base/model"
 	adminapi "github.com/dabbertorres/web-srv-base/model/admin"
 	userapi "github.com/dabbertorres/web-srv-base/model/user"
+	"github.com/dabbertorres/web-srv-base/perms"
+	"github.com/dabbertorres/web-srv-base/realip"
+	"github.com/dabbertorres/web-srv-base/reqid"
 	"github.com/dabbertorres/web-srv-base/tmpl"
+	"github.com/dabbertorres/web-srv-base/tokens"
+	"github.com/dabbertorres/web-srv-base/trace"
 	"github.com/dabbertorres/web-srv-base/view"
 	"github.com/dabbertorres/web-srv-base/view/admin"
 	"github.com/dabbertorres/web-srv-base/view/user"
@@ -26,7 +31,7 @@ func pageHandler(templateName string, builder view.Builder) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		data, err := builder(r)
 		if err != nil {
-			logme.Err().Printf("Serving template '%s' for '%s': %v\n", templateName, r.RequestURI, err)
+			logger.Ctx(r.Context()).Error("Serving template", "template", templateName, "uri", r.RequestURI, "err", err)
 
 			if buildErr, ok := err.(view.Error); ok {
 				w.WriteHeader(buildErr.Status)
@@ -36,9 +41,22 @@ func pageHandler(templateName string, builder view.Builder) http.HandlerFunc {
 			return
 		}
 
-		err = tmpl.Build(templateName, w, data)
+		err = tmpl.BuildRequest(templateName, w, r, data)
 		if err != nil {
-			logme.Err().Printf("Building template '%s' for '%s': %v\n", templateName, r.RequestURI, err)
+			logger.Ctx(r.Context()).Error("Building template", "template", templateName, "uri", r.RequestURI, "err", err)
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}
+}
+
+// titledPageHandler serves a page that needs nothing but a title - it fetches anything else itself
+func titledPageHandler(templateName, title string) http.HandlerFunc {
+	data := struct{ Title string }{title}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		err := tmpl.BuildRequest(templateName, w, r, &data)
+		if err != nil {
+			logger.Ctx(r.Context()).Error("Building template", "template", templateName, "uri", r.RequestURI, "err", err)
 			w.WriteHeader(http.StatusInternalServerError)
 		}
 	}
@@ -48,7 +66,7 @@ func staticFileHandler(filepath string) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		buf, err := ioutil.ReadFile(filepath)
 		if err != nil {
-			logme.Err().Printf("Serving static file '%s': %v\n", filepath, err)
+			logger.Ctx(r.Context()).Error("Serving static file", "path", filepath, "err", err)
 			w.WriteHeader(http.StatusInternalServerError)
 		} else {
 			w.Write(buf)
@@ -59,11 +77,11 @@ func staticFileHandler(filepath string) http.HandlerFunc {
 func RegisterRoutes(router *mux.Router) {
 	router.NotFoundHandler = http.HandlerFunc(
 		func(w http.ResponseWriter, r *http.Request) {
-			err := tmpl.Build("pages/404", w, &view.NotFound{})
+			w.WriteHeader(http.StatusNotFound)
+			err := tmpl.Build(r.Context(), "pages/404", w, &view.NotFound{})
 			if err != nil {
-				logme.Err().Println("Serving 404 page:", err)
+				logger.Ctx(r.Context()).Error("Serving 404 page", "err", err)
 			}
-			w.WriteHeader(http.StatusNotFound)
 		})
 
 	// static content
@@ -75,7 +93,7 @@ func RegisterRoutes(router *mux.Router) {
 
 		filepath.Walk(base, func(path string, info os.FileInfo, err error) error {
 			if err != nil {
-				logme.Err().Printf("creating route for '%s' error: %v\n", path, err)
+				logger.Error("Creating route", "path", path, "err", err)
 			} else if info.Mode().IsRegular() {
 				routePath := strings.TrimPrefix(path, pathBase)
 				routePath = strings.TrimSuffix(routePath, filepath.Ext(routePath))
@@ -86,8 +104,13 @@ func RegisterRoutes(router *mux.Router) {
 		})
 	}
 
-	router.Use(dialogue.Middleware)
+	router.Use(trace.Middleware)
+	router.Use(metrics.Route)
+	router.Use(reqid.Middleware)
+	router.Use(realip.Middleware)
 	router.Use(db.Middleware)
+	router.Use(tokens.Middleware)
+	router.Use(dialogue.Middleware)
 	router.Use(visitors.Middleware)
 
 	var (
@@ -98,8 +121,27 @@ func RegisterRoutes(router *mux.Router) {
 	adminR.Use(adminapi.Middleware)
 	userR.Use(userapi.Middleware)
 
+	// admin routes grouped by the permission they require
+	var (
+		adminVisitsR      = adminR.NewRoute().Subrouter()
+		adminUsersR       = adminR.NewRoute().Subrouter()
+		adminSessionsR    = adminR.NewRoute().Subrouter()
+		adminImpersonateR = adminR.NewRoute().Subrouter()
+		adminAuditR       = adminR.NewRoute().Subrouter()
+	)
+
+	adminVisitsR.Use(perms.RequirePermission(perms.VisitsView))
+	adminUsersR.Use(perms.RequirePermission(perms.UsersManage))
+	adminSessionsR.Use(perms.RequirePermission(perms.SessionsRevoke))
+	adminImpersonateR.Use(perms.RequirePermission(perms.UsersImpersonate))
+	adminAuditR.Use(perms.RequirePermission(perms.AuditView))
+
 	baseEndpoints(router)
-	adminEndpoints(adminR)
+	adminVisitsEndpoints(adminVisitsR)
+	adminUsersEndpoints(adminUsersR)
+	adminSessionsEndpoints(adminSessionsR)
+	adminImpersonateEndpoints(adminImpersonateR)
+	adminAuditEndpoints(adminAuditR)
 	userEndpoints(userR)
 
 	loginViews(router)
@@ -115,18 +157,126 @@ func baseEndpoints(router *mux.Router) {
 	router.Path("/login").
 		Methods(http.MethodDelete).
 		HandlerFunc(model.Logout)
+
+	router.Path("/login/oidc").
+		Methods(http.MethodGet).
+		HandlerFunc(model.OIDCLogin)
+
+	router.Path("/login/oidc/callback").
+		Methods(http.MethodGet).
+		HandlerFunc(model.OIDCCallback)
 }
 
-func adminEndpoints(router *mux.Router) {
-	router.Path("/visits").
+func adminVisitsEndpoints(router *mux.Router) {
+	tokens.Scoped(tokens.ScopeVisitsRead, router.Path("/visits").
 		Methods(http.MethodGet).
-		HandlerFunc(adminapi.Visits)
+		HandlerFunc(adminapi.Visits))
+
+	tokens.Scoped(tokens.ScopeVisitsRead, router.Path("/visits/traffic").
+		Methods(http.MethodGet).
+		HandlerFunc(adminapi.VisitsTraffic))
+
+	tokens.Scoped(tokens.ScopeVisitsRead, router.Path("/visits/top").
+		Methods(http.MethodGet).
+		HandlerFunc(adminapi.VisitsTop))
+
+	tokens.Scoped(tokens.ScopeVisitsRead, router.Path("/visits/campaigns").
+		Methods(http.MethodGet).
+		HandlerFunc(adminapi.VisitsCampaigns))
+
+	tokens.Scoped(tokens.ScopeVisitsRead, router.Path("/visits/live").
+		Methods(http.MethodGet).
+		HandlerFunc(adminapi.VisitsLive))
+}
+
+func adminUsersEndpoints(router *mux.Router) {
+	tokens.Scoped(tokens.ScopeUsersAdmin, router.Path("/roles").
+		Methods(http.MethodGet).
+		HandlerFunc(adminapi.Roles))
+
+	tokens.Scoped(tokens.ScopeUsersAdmin, router.Path("/roles/{role}").
+		Methods(http.MethodPut).
+		HandlerFunc(adminapi.RoleSet))
+
+	tokens.Scoped(tokens.ScopeUsersAdmin, router.Path("/roles/{role}").
+		Methods(http.MethodDelete).
+		HandlerFunc(adminapi.RoleDelete))
+
+	tokens.Scoped(tokens.ScopeUsersAdmin, router.Path("/users/{name}/roles").
+		Methods(http.MethodGet).
+		HandlerFunc(adminapi.UserRoles))
+
+	tokens.Scoped(tokens.ScopeUsersAdmin, router.Path("/users/{name}/roles/{role}").
+		Methods(http.MethodPut).
+		HandlerFunc(adminapi.UserAddRole))
+
+	tokens.Scoped(tokens.ScopeUsersAdmin, router.Path("/users/{name}/roles/{role}").
+		Methods(http.MethodDelete).
+		HandlerFunc(adminapi.UserRemoveRole))
+
+	tokens.Scoped(tokens.ScopeUsersAdmin, router.Path("/users/{name}/enabled").
+		Methods(http.MethodPut).
+		HandlerFunc(adminapi.UserEnable))
+
+	tokens.Scoped(tokens.ScopeUsersAdmin, router.Path("/users/{name}/enabled").
+		Methods(http.MethodDelete).
+		HandlerFunc(adminapi.UserDisable))
+
+	tokens.Scoped(tokens.ScopeUsersAdmin, router.Path("/users/{name}/activity").
+		Methods(http.MethodGet).
+		HandlerFunc(adminapi.UserActivity))
+}
+
+func adminSessionsEndpoints(router *mux.Router) {
+	tokens.Scoped(tokens.ScopeUsersAdmin, router.Path("/users/{name}/sessions/{id:[0-9a-f]+}").
+		Methods(http.MethodDelete).
+		HandlerFunc(adminapi.SessionRevoke))
+}
+
+func adminAuditEndpoints(router *mux.Router) {
+	tokens.Scoped(tokens.ScopeAuditRead, router.Path("/audit").
+		Methods(http.MethodGet).
+		HandlerFunc(adminapi.Audit))
+}
+
+func adminImpersonateEndpoints(router *mux.Router) {
+	router.Path("/users/{name}/impersonate").
+		Methods(http.MethodPost).
+		HandlerFunc(adminapi.Impersonate)
 }
 
 func userEndpoints(router *mux.Router) {
 	router.Path("/new").
 		Methods(http.MethodPost).
 		HandlerFunc(userapi.New)
+
+	router.Path("/tokens").
+		Methods(http.MethodGet).
+		HandlerFunc(userapi.Tokens)
+
+	router.Path("/tokens").
+		Methods(http.MethodPost).
+		HandlerFunc(userapi.TokenNew)
+
+	router.Path("/tokens/{id:[0-9]+}").
+		Methods(http.MethodDelete).
+		HandlerFunc(userapi.TokenRevoke)
+
+	router.Path("/password").
+		Methods(http.MethodPost).
+		HandlerFunc(userapi.ChangePassword)
+
+	router.Path("/impersonation/stop").
+		Methods(http.MethodPost).
+		HandlerFunc(userapi.StopImpersonating)
+
+	router.Path("/logins").
+		Methods(http.MethodGet).
+		HandlerFunc(userapi.Logins)
+
+	router.Path("/sessions").
+		Methods(http.MethodGet).
+		HandlerFunc(userapi.Sessions)
 }
 
 func loginViews(router *mux.Router) {
@@ -143,6 +293,10 @@ func userViews(router *mux.Router) {
 	router.Path("/profile/{username}").
 		Methods(http.MethodGet).
 		HandlerFunc(user.Profile)
+
+	router.Path("/activity").
+		Methods(http.MethodGet).
+		HandlerFunc(titledPageHandler("pages/user/activity", "Activity"))
 }
 
 func adminViews(router *mux.Router) {
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"io/ioutil"
 	"strings"
 	"time"
@@ -9,15 +11,34 @@ import (
 	"golang.org/x/crypto/acme/autocert"
 
 	"github.com/dabbertorres/how"
+	"github.com/dabbertorres/web-srv-base/accesslog"
+	"github.com/dabbertorres/web-srv-base/db"
+	"github.com/dabbertorres/web-srv-base/dialogue"
+	"github.com/dabbertorres/web-srv-base/geoip"
+	"github.com/dabbertorres/web-srv-base/health"
+	"github.com/dabbertorres/web-srv-base/logme"
+	"github.com/dabbertorres/web-srv-base/mail"
+	"github.com/dabbertorres/web-srv-base/oidc"
+	"github.com/dabbertorres/web-srv-base/tmpl"
+	"github.com/dabbertorres/web-srv-base/trace"
+	"github.com/dabbertorres/web-srv-base/visitors"
 )
 
 const (
 	dbPassFile = "/run/secrets/web-srv-db-password"
+	oidcSecret = "/run/secrets/web-srv-oidc-client-secret"
+	visitIPKey = "/run/secrets/web-srv-visit-ip-key"
 	certsDir   = "/certs"
 	confFile   = "/web.conf"
+
+	migrationsDir = "/migrations"
 )
 
+var errTemplatesNotLoaded = errors.New("templates not loaded")
+
 func LoadConfig() (cfg Config, err error) {
+	cfg = DefaultConfig()
+
 	err = how.ParseWithFile(&cfg, confFile)
 	if err != nil {
 		return
@@ -34,6 +55,118 @@ func LoadConfig() (cfg Config, err error) {
 	return
 }
 
+func LogSetup(cfg *Config) (err error) {
+	err = logme.SetFormat(cfg.LogFormat)
+	if err != nil {
+		return
+	}
+
+	level, err := logme.ParseLevel(cfg.LogLevel)
+	if err != nil {
+		return
+	}
+	logme.SetLevel(level)
+
+	err = logme.SetLevels(cfg.LogLevels)
+	if err != nil {
+		return
+	}
+
+	logme.SetRotation(logme.Rotation{
+		MaxSize:  int64(cfg.LogMaxSize) * 1024 * 1024,
+		Interval: time.Duration(cfg.LogRotate) * time.Hour,
+		MaxAge:   time.Duration(cfg.LogMaxAge) * 24 * time.Hour,
+		MaxCount: cfg.LogMaxFiles,
+		Compress: cfg.LogCompress,
+	})
+
+	return accesslog.Open(cfg.AccessLog)
+}
+
+func TraceSetup(cfg *Config) error {
+	if cfg.TraceEndpoint == "" {
+		return nil
+	}
+	return trace.Open(cfg.TraceEndpoint, cfg.TraceName)
+}
+
+// HealthSetup makes readiness depend on everything a request might need.
+func HealthSetup() {
+	health.Register("db", db.Ping)
+	health.Register("sessions", dialogue.Check)
+	health.Register("mail", mail.Ping)
+	health.Register("templates", func(context.Context) error {
+		if !tmpl.Loaded() {
+			return errTemplatesNotLoaded
+		}
+		return nil
+	})
+}
+
+func VisitorsSetup(cfg *Config) (err error) {
+	if cfg.VisitRules != "" {
+		err = visitors.LoadRules(cfg.VisitRules)
+		if err != nil {
+			return
+		}
+	}
+
+	var key []byte
+	if cfg.VisitIPMode == visitors.IPHash {
+		key, err = ioutil.ReadFile(visitIPKey)
+		if err != nil {
+			return
+		}
+	}
+
+	err = visitors.SetPrivacy(cfg.VisitIPMode, key, cfg.VisitDNT)
+	if err != nil {
+		return
+	}
+
+	err = visitors.Start(cfg.VisitQueue, cfg.VisitBatch, time.Duration(cfg.VisitFlush)*time.Millisecond)
+	if err != nil {
+		return
+	}
+
+	if cfg.VisitRetention > 0 {
+		err = visitors.StartRetention(time.Duration(cfg.VisitRetention)*24*time.Hour, cfg.VisitRollup)
+	}
+
+	return
+}
+
+func GeoIPSetup(cfg *Config) (err error) {
+	err = geoip.Open(cfg.GeoIPDB)
+	if err != nil {
+		return
+	}
+
+	if cfg.GeoIPReload > 0 {
+		err = geoip.Watch(cfg.GeoIPDB, time.Duration(cfg.GeoIPReload)*time.Second)
+	}
+	return
+}
+
+func OIDCSetup(ctx context.Context, cfg *Config) error {
+	secret, err := ioutil.ReadFile(oidcSecret)
+	if err != nil {
+		return err
+	}
+
+	redirect := cfg.OIDCRedirect
+	if redirect == "" {
+		redirect = "https://" + cfg.Hostname + "/login/oidc/callback"
+	}
+
+	scope := cfg.OIDCScope
+	if scope == "" {
+		scope = oidcScope
+	}
+
+	return oidc.Open(ctx, cfg.OIDCIssuer, cfg.OIDCClientID, strings.TrimSpace(string(secret)), redirect, strings.Fields(scope))
+}
+
 func LetsEncryptSetup(cfg *Config) *autocert.Manager {
 	return &autocert.Manager{
 		Prompt:      autocert.AcceptTOS,
@@ -1,13 +1,19 @@
 package tmpl
 
 import (
+	"context"
 	"html/template"
 	"io"
 	"io/ioutil"
+	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/dabbertorres/web-srv-base/dialogue"
+	"github.com/dabbertorres/web-srv-base/trace"
 	"github.com/dabbertorres/web-srv-base/view"
 )
 
@@ -17,12 +23,23 @@ const (
 )
 
 var (
+	// never executed, so it can be cloned for per-request functions
+	base      *template.Template
 	templates *template.Template
+
+	// placeholders so templates can parse - BuildRequest replaces them per request
+	requestFuncs = template.FuncMap{
+		"impersonator": func() string { return "" },
+	}
+
+	// clones of base for each admin currently impersonating someone, so they're only cloned once
+	impersonatingMutex sync.Mutex
+	impersonating      map[string]*template.Template
 )
 
 func Load(appPath string) (err error) {
 	// non-nil empty template
-	templates = template.New("base").Option("missingkey=zero")
+	base = template.New("base").Option("missingkey=zero").Funcs(requestFuncs)
 
 	walk := func(path string, info os.FileInfo, err error) error {
 		if err == nil && info.Mode().IsRegular() && info.Size() > 0 {
@@ -40,7 +57,7 @@ func Load(appPath string) (err error) {
 				return err
 			}
 
-			_, err = templates.New(relPath).Parse(string(buf))
+			_, err = base.New(relPath).Parse(string(buf))
 		}
 
 		return err
@@ -56,11 +73,66 @@ func Load(appPath string) (err error) {
 		return
 	}
 
+	templates, err = base.Clone()
+
+	impersonatingMutex.Lock()
+	impersonating = make(map[string]*template.Template)
+	impersonatingMutex.Unlock()
+	return
+}
+
+// Build writes page, executed with data, to w. It's traced as part of ctx's trace.
+func Build(ctx context.Context, page string, w io.Writer, data view.Data) (err error) {
+	start := time.Now()
+	err = templates.ExecuteTemplate(w, page, data)
+	trace.Record(ctx, "template "+page, trace.KindInternal, start, err, "template", page)
+	return
+}
+
+// BuildRequest is Build, but with template functions that describe r, ie: "impersonator".
+func BuildRequest(page string, w io.Writer, r *http.Request, data view.Data) (err error) {
+	start := time.Now()
+	defer func() {
+		trace.Record(r.Context(), "template "+page, trace.KindInternal, start, err, "template", page)
+	}()
+
+	t := templates
+	if isImpersonating, impersonator := dialogue.Impersonator(r); isImpersonating {
+		t, err = forImpersonator(impersonator)
+		if err != nil {
+			return
+		}
+	}
+
+	return t.ExecuteTemplate(w, page, data)
+}
+
+// forImpersonator returns the templates with "impersonator" returning name.
+func forImpersonator(name string) (t *template.Template, err error) {
+	impersonatingMutex.Lock()
+	defer impersonatingMutex.Unlock()
+
+	t, ok := impersonating[name]
+	if ok {
+		return
+	}
+
+	t, err = base.Clone()
+	if err != nil {
+		return
+	}
+
+	t.Funcs(template.FuncMap{
+		"impersonator": func() string { return name },
+	})
+
+	impersonating[name] = t
 	return
 }
 
-func Build(page string, w io.Writer, data view.Data) error {
-	return templates.ExecuteTemplate(w, page, data)
+// Loaded reports whether Load has loaded the templates.
+func Loaded() bool {
+	return templates != nil
 }
 
 func Pages() <-chan string {
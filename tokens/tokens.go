@@ -0,0 +1,119 @@
+package tokens
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/mux"
+
+	"github.com/dabbertorres/web-srv-base/db"
+	"github.com/dabbertorres/web-srv-base/dialogue"
+	"github.com/dabbertorres/web-srv-base/logme"
+	"github.com/dabbertorres/web-srv-base/perms"
+)
+
+const (
+	ScopeVisitsRead = "visits:read"
+	ScopeUsersAdmin = "users:admin"
+	ScopeAuditRead  = "audit:read"
+)
+
+const (
+	bearerPrefix = "Bearer "
+)
+
+type tokenCtxKey struct{}
+
+var (
+	// all known scopes, and the permission a user needs to grant them to their tokens
+	scopes = map[string]string{
+		ScopeVisitsRead: perms.VisitsView,
+		ScopeUsersAdmin: perms.UsersManage,
+		ScopeAuditRead:  perms.AuditView,
+	}
+
+	mutex       sync.RWMutex
+	routeScopes = make(map[*mux.Route]string)
+
+	logger = logme.For("tokens")
+)
+
+// Valid reports whether scope exists, and the permission needed to grant it.
+func Valid(scope string) (exists bool, permission string) {
+	permission, exists = scopes[scope]
+	return
+}
+
+// Scoped allows route to be used by tokens that have scope. Routes that aren't Scoped can't be used with tokens at all.
+func Scoped(scope string, route *mux.Route) *mux.Route {
+	mutex.Lock()
+	routeScopes[route] = scope
+	mutex.Unlock()
+	return route
+}
+
+// Middleware authenticates requests carrying an "Authorization: Bearer" token as the token's user.
+// Such requests are given a session that lasts only for the request, so must be installed before dialogue.Middleware.
+// It requires a db connection, so must be installed after db.Middleware.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, bearerPrefix) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		token, err := db.TokenAuthenticate(r.Context(), strings.TrimSpace(strings.TrimPrefix(auth, bearerPrefix)))
+		if err != nil {
+			if err != db.ErrTokenNotExist {
+				logger.Ctx(r.Context()).Error("authenticating token", "err", err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		mutex.RLock()
+		scope, ok := routeScopes[mux.CurrentRoute(r)]
+		mutex.RUnlock()
+
+		if !ok || !hasScope(&token, scope) {
+			logger.Ctx(r.Context()).Warn("token used without scope", "token", token.ID, "user", token.User, "scope", scope, "uri", r.RequestURI)
+			w.Header().Set("WWW-Authenticate", `Bearer error="insufficient_scope", scope="`+scope+`"`)
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), tokenCtxKey{}, &token)
+		ctx = logme.NewContext(ctx, "user", token.User, "token", token.ID)
+		r = r.WithContext(ctx)
+		next.ServeHTTP(w, dialogue.WithUser(r, token.User))
+	})
+}
+
+// FromRequest returns the token r was authenticated with, if it was.
+func FromRequest(r *http.Request) (token *db.Token, ok bool) {
+	token, ok = r.Context().Value(tokenCtxKey{}).(*db.Token)
+	return
+}
+
+// Allows reports whether r may be used for scope. Requests not authenticated with a token always may, as they're
+// limited only by their user's permissions.
+func Allows(r *http.Request, scope string) bool {
+	token, ok := FromRequest(r)
+	return !ok || hasScope(token, scope)
+}
+
+func hasScope(token *db.Token, scope string) bool {
+	for _, s := range token.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,307 @@
+package trace
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/dabbertorres/web-srv-base/logme"
+)
+
+var (
+	ErrAlreadyOpen = errors.New("tracing already started")
+	ErrNotOpen     = errors.New("tracing not started")
+)
+
+const (
+	queueSize     = 4096
+	batchSize     = 512
+	flushInterval = 5 * time.Second
+	exportTimeout = 10 * time.Second
+
+	// the instrumentation scope of every span
+	scopeName = "github.com/dabbertorres/web-srv-base"
+)
+
+var (
+	endpoint string
+	service  string
+	client   = &http.Client{Timeout: exportTimeout}
+
+	queue chan *Span
+	stop  chan struct{}
+	done  chan struct{}
+
+	enabled int32
+
+	// drops since the last time they were logged
+	dropped uint64
+
+	logger = logme.For("trace")
+)
+
+// Open starts exporting spans, as OTLP/HTTP JSON, to the collector at url (ie: http://collector:4318/v1/traces), as
+// from the service named name. Until then, no spans are recorded.
+func Open(url, name string) error {
+	if queue != nil {
+		return ErrAlreadyOpen
+	}
+
+	endpoint = url
+	service = name
+
+	queue = make(chan *Span, queueSize)
+	stop = make(chan struct{})
+	done = make(chan struct{})
+
+	go worker()
+	atomic.StoreInt32(&enabled, 1)
+	return nil
+}
+
+// Close exports any queued spans, and stops recording new ones.
+func Close(ctx context.Context) error {
+	if queue == nil {
+		return ErrNotOpen
+	}
+
+	atomic.StoreInt32(&enabled, 0)
+	close(stop)
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Enabled reports whether spans are being recorded.
+func Enabled() bool {
+	return atomic.LoadInt32(&enabled) == 1
+}
+
+// enqueue never blocks; if the exporter can't keep up, the span is dropped.
+func enqueue(s *Span) {
+	if !Enabled() {
+		return
+	}
+
+	select {
+	case queue <- s:
+	default:
+		atomic.AddUint64(&dropped, 1)
+	}
+}
+
+func worker() {
+	defer close(done)
+
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]*Span, 0, batchSize)
+
+	for {
+		select {
+		case s := <-queue:
+			batch = append(batch, s)
+			if len(batch) >= batchSize {
+				batch = flush(batch)
+			}
+
+		case <-ticker.C:
+			batch = flush(batch)
+
+		case <-stop:
+			for {
+				select {
+				case s := <-queue:
+					batch = append(batch, s)
+					if len(batch) >= batchSize {
+						batch = flush(batch)
+					}
+
+				default:
+					flush(batch)
+					return
+				}
+			}
+		}
+	}
+}
+
+// flush exports batch, returning it emptied for reuse
+func flush(batch []*Span) []*Span {
+	if n := atomic.SwapUint64(&dropped, 0); n != 0 {
+		logger.Warn("dropped spans, queue was full", "count", n)
+	}
+
+	if len(batch) == 0 {
+		return batch
+	}
+
+	err := export(batch)
+	if err != nil {
+		logger.Warn("exporting spans", "count", len(batch), "err", err)
+	}
+
+	for i := range batch {
+		batch[i] = nil
+	}
+	return batch[:0]
+}
+
+func export(batch []*Span) error {
+	spans := make([]otlpSpan, len(batch))
+	for i, s := range batch {
+		spans[i] = s.otlp()
+	}
+
+	body, err := json.Marshal(&otlpRequest{
+		ResourceSpans: []otlpResourceSpans{{
+			Resource: otlpResource{
+				Attributes: attributes([]interface{}{"service.name", service}),
+			},
+			ScopeSpans: []otlpScopeSpans{{
+				Scope: otlpScope{Name: scopeName},
+				Spans: spans,
+			}},
+		}},
+	})
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.Post(endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("collector responded %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+
+	io.Copy(ioutil.Discard, resp.Body)
+	return nil
+}
+
+// OTLP/HTTP's JSON encoding of an ExportTraceServiceRequest - IDs are hex, and 64 bit integers are strings.
+type (
+	otlpRequest struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+
+	otlpResourceSpans struct {
+		Resource   otlpResource     `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+
+	otlpResource struct {
+		Attributes []otlpAttribute `json:"attributes"`
+	}
+
+	otlpScopeSpans struct {
+		Scope otlpScope  `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+
+	otlpScope struct {
+		Name string `json:"name"`
+	}
+
+	otlpSpan struct {
+		TraceID           string          `json:"traceId"`
+		SpanID            string          `json:"spanId"`
+		ParentSpanID      string          `json:"parentSpanId,omitempty"`
+		Name              string          `json:"name"`
+		Kind              Kind            `json:"kind"`
+		StartTimeUnixNano string          `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+		Attributes        []otlpAttribute `json:"attributes,omitempty"`
+		Status            otlpStatus      `json:"status"`
+	}
+
+	otlpStatus struct {
+		Code    int    `json:"code,omitempty"` // 0: unset, 1: ok, 2: error
+		Message string `json:"message,omitempty"`
+	}
+
+	otlpAttribute struct {
+		Key   string    `json:"key"`
+		Value otlpValue `json:"value"`
+	}
+
+	otlpValue struct {
+		String *string  `json:"stringValue,omitempty"`
+		Int    *string  `json:"intValue,omitempty"`
+		Double *float64 `json:"doubleValue,omitempty"`
+		Bool   *bool    `json:"boolValue,omitempty"`
+	}
+)
+
+const statusError = 2
+
+func (s *Span) otlp() (span otlpSpan) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	span = otlpSpan{
+		TraceID:           s.context.TraceID.String(),
+		SpanID:            s.context.SpanID.String(),
+		Name:              s.name,
+		Kind:              s.kind,
+		StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+		Attributes:        attributes(s.attrs),
+	}
+	if s.parent.IsValid() {
+		span.ParentSpanID = s.parent.String()
+	}
+	if s.err != nil {
+		span.Status = otlpStatus{Code: statusError, Message: s.err.Error()}
+	}
+	return
+}
+
+// attributes converts alternating keys and values, like logme's, to OTLP's attributes
+func attributes(kv []interface{}) (attrs []otlpAttribute) {
+	for i := 0; i+1 < len(kv); i += 2 {
+		key, ok := kv[i].(string)
+		if !ok {
+			key = fmt.Sprint(kv[i])
+		}
+
+		var value otlpValue
+		switch v := kv[i+1].(type) {
+		case string:
+			value.String = &v
+		case bool:
+			value.Bool = &v
+		case int:
+			s := strconv.Itoa(v)
+			value.Int = &s
+		case int64:
+			s := strconv.FormatInt(v, 10)
+			value.Int = &s
+		case float64:
+			value.Double = &v
+		default:
+			s := fmt.Sprint(v)
+			value.String = &s
+		}
+
+		attrs = append(attrs, otlpAttribute{Key: key, Value: value})
+	}
+	return
+}
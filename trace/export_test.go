@@ -0,0 +1,362 @@
+package trace
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// collector is a fake OTLP/HTTP collector, keeping the spans exported to it.
+type collector struct {
+	*httptest.Server
+
+	mutex sync.Mutex
+	// as decoded JSON, to check the wire format rather than our own types
+	requests []map[string]interface{}
+	errs     []string
+}
+
+func newCollector(t *testing.T) *collector {
+	t.Helper()
+
+	c := &collector{}
+	c.Server = httptest.NewServer(http.HandlerFunc(c.serve))
+	t.Cleanup(c.Close)
+	return c
+}
+
+func (c *collector) serve(w http.ResponseWriter, r *http.Request) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	switch {
+	case r.Method != http.MethodPost:
+		c.errs = append(c.errs, "method "+r.Method)
+	case r.URL.Path != "/v1/traces":
+		c.errs = append(c.errs, "path "+r.URL.Path)
+	case r.Header.Get("Content-Type") != "application/json":
+		c.errs = append(c.errs, "content type "+r.Header.Get("Content-Type"))
+	}
+
+	var req map[string]interface{}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		c.errs = append(c.errs, "decoding: "+err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	c.requests = append(c.requests, req)
+}
+
+// spans returns every exported span, by name, checking the layout of the requests along the way.
+func (c *collector) spans(t *testing.T) map[string]map[string]interface{} {
+	t.Helper()
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for _, err := range c.errs {
+		t.Error(err)
+	}
+
+	spans := make(map[string]map[string]interface{})
+	for _, req := range c.requests {
+		for _, rs := range list(t, req, "resourceSpans") {
+			resource := object(t, rs, "resource")
+			if got := attribute(t, resource, "service.name"); got != "test-service" {
+				t.Errorf("service.name = %v, want test-service", got)
+			}
+
+			for _, ss := range list(t, rs, "scopeSpans") {
+				if name := object(t, ss, "scope")["name"]; name != scopeName {
+					t.Errorf("scope name = %v, want %s", name, scopeName)
+				}
+
+				for _, span := range list(t, ss, "spans") {
+					name, _ := span["name"].(string)
+					spans[name] = span
+				}
+			}
+		}
+	}
+	return spans
+}
+
+func object(t *testing.T, v map[string]interface{}, key string) map[string]interface{} {
+	t.Helper()
+
+	obj, ok := v[key].(map[string]interface{})
+	if !ok {
+		t.Fatalf("%q is %T, not an object", key, v[key])
+	}
+	return obj
+}
+
+func list(t *testing.T, v map[string]interface{}, key string) (objs []map[string]interface{}) {
+	t.Helper()
+
+	l, ok := v[key].([]interface{})
+	if !ok {
+		t.Fatalf("%q is %T, not a list", key, v[key])
+	}
+
+	for _, e := range l {
+		obj, ok := e.(map[string]interface{})
+		if !ok {
+			t.Fatalf("element of %q is %T, not an object", key, e)
+		}
+		objs = append(objs, obj)
+	}
+	return
+}
+
+// attribute returns the value of v's attribute key, whichever of OTLP's typed values it was encoded as
+func attribute(t *testing.T, v map[string]interface{}, key string) interface{} {
+	t.Helper()
+
+	for _, attr := range list(t, v, "attributes") {
+		if attr["key"] != key {
+			continue
+		}
+
+		value := object(t, attr, "value")
+		for _, typ := range []string{"stringValue", "intValue", "doubleValue", "boolValue"} {
+			if v, ok := value[typ]; ok {
+				return v
+			}
+		}
+		t.Fatalf("attribute %q has no value: %v", key, value)
+	}
+	return nil
+}
+
+// openCollector starts exporting to c, and stops when the test ends, if the test didn't already.
+func openCollector(t *testing.T, c *collector) {
+	t.Helper()
+
+	err := Open(c.URL+"/v1/traces", "test-service")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	t.Cleanup(reset)
+}
+
+// closeExporter flushes every span to the collector.
+func closeExporter(t *testing.T) {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err := Close(ctx)
+	if err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+}
+
+func reset() {
+	if Enabled() {
+		Close(context.Background())
+	}
+	queue = nil
+	stop = nil
+	done = nil
+}
+
+func TestNotOpen(t *testing.T) {
+	ctx, span := Start(context.Background(), "nothing", KindInternal)
+	if span != nil || FromContext(ctx) != nil {
+		t.Error("started a span without tracing on")
+	}
+
+	// a nil span must be safe to use
+	span.SetAttributes("key", "value")
+	span.SetError(errors.New("failed"))
+	span.End()
+
+	if err := Close(context.Background()); err != ErrNotOpen {
+		t.Errorf("Close() = %v, want %v", err, ErrNotOpen)
+	}
+}
+
+func TestExport(t *testing.T) {
+	c := newCollector(t)
+	openCollector(t, c)
+
+	if err := Open(c.URL, "again"); err != ErrAlreadyOpen {
+		t.Fatalf("second Open() = %v, want %v", err, ErrAlreadyOpen)
+	}
+
+	ctx, root := Start(context.Background(), "root", KindServer, "http.method", "GET", "count", 3, "big", int64(1)<<40)
+
+	childCtx, child := Start(ctx, "child", KindInternal, "ratio", 0.5, "ok", true)
+	if FromContext(childCtx) != child {
+		t.Fatal("child span isn't in its context")
+	}
+
+	Record(childCtx, "grandchild", KindClient, time.Now().Add(-time.Millisecond), errors.New("query failed"))
+
+	child.End()
+	root.End()
+	// only the first End counts
+	root.End()
+
+	closeExporter(t)
+
+	spans := c.spans(t)
+	if len(spans) != 3 {
+		t.Fatalf("exported %d spans, want 3: %v", len(spans), spans)
+	}
+
+	var (
+		rootSpan       = spans["root"]
+		childSpan      = spans["child"]
+		grandchildSpan = spans["grandchild"]
+	)
+
+	if rootSpan["traceId"] != root.Context().TraceID.String() || rootSpan["spanId"] != root.Context().SpanID.String() {
+		t.Errorf("root IDs = %v, %v", rootSpan["traceId"], rootSpan["spanId"])
+	}
+	if _, ok := rootSpan["parentSpanId"]; ok {
+		t.Errorf("root has a parent: %v", rootSpan["parentSpanId"])
+	}
+
+	for name, span := range map[string]map[string]interface{}{"child": childSpan, "grandchild": grandchildSpan} {
+		if span["traceId"] != rootSpan["traceId"] {
+			t.Errorf("%s is in trace %v, not the root's %v", name, span["traceId"], rootSpan["traceId"])
+		}
+	}
+	if childSpan["parentSpanId"] != rootSpan["spanId"] {
+		t.Errorf("child's parent = %v, want %v", childSpan["parentSpanId"], rootSpan["spanId"])
+	}
+	if grandchildSpan["parentSpanId"] != childSpan["spanId"] {
+		t.Errorf("grandchild's parent = %v, want %v", grandchildSpan["parentSpanId"], childSpan["spanId"])
+	}
+
+	// OTLP/JSON numbers kinds, and encodes 64 bit integers as strings
+	if rootSpan["kind"] != float64(KindServer) || grandchildSpan["kind"] != float64(KindClient) {
+		t.Errorf("kinds = %v, %v", rootSpan["kind"], grandchildSpan["kind"])
+	}
+	for _, key := range []string{"startTimeUnixNano", "endTimeUnixNano"} {
+		if _, ok := rootSpan[key].(string); !ok {
+			t.Errorf("%s is %T, not a string", key, rootSpan[key])
+		}
+	}
+
+	attrs := map[string]interface{}{
+		"http.method": attribute(t, rootSpan, "http.method"),
+		"count":       attribute(t, rootSpan, "count"),
+		"big":         attribute(t, rootSpan, "big"),
+		"ratio":       attribute(t, childSpan, "ratio"),
+		"ok":          attribute(t, childSpan, "ok"),
+	}
+	want := map[string]interface{}{
+		"http.method": "GET",
+		"count":       "3",
+		"big":         "1099511627776",
+		"ratio":       0.5,
+		"ok":          true,
+	}
+	for k, v := range want {
+		if attrs[k] != v {
+			t.Errorf("attribute %s = %#v, want %#v", k, attrs[k], v)
+		}
+	}
+
+	status := object(t, grandchildSpan, "status")
+	if status["code"] != float64(statusError) || status["message"] != "query failed" {
+		t.Errorf("grandchild status = %v", status)
+	}
+	if status := object(t, rootSpan, "status"); len(status) != 0 {
+		t.Errorf("root status = %v, want unset", status)
+	}
+
+	// once closed, nothing more is recorded
+	if _, span := Start(context.Background(), "late", KindInternal); span != nil {
+		t.Error("started a span after Close")
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	const (
+		remoteTrace = "4bf92f3577b34da6a3ce929d0e0e4736"
+		remoteSpan  = "00f067aa0ba902b7"
+	)
+
+	c := newCollector(t)
+	openCollector(t, c)
+
+	// by request
+	var propagated []string
+
+	router := mux.NewRouter()
+	router.Use(Middleware)
+	router.Path("/users/{name}").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// an outgoing request would continue the trace from the server span
+		header := http.Header{}
+		Inject(r.Context(), header)
+		propagated = append(propagated, header.Get(TraceparentHeader))
+
+		Record(r.Context(), "db select", KindClient, time.Now(), nil)
+		w.WriteHeader(http.StatusBadGateway)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/users/bob", nil)
+	req.Header.Set(TraceparentHeader, "00-"+remoteTrace+"-"+remoteSpan+"-01")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	// an unsampled caller's trace isn't recorded
+	req = httptest.NewRequest(http.MethodGet, "/users/alice", nil)
+	req.Header.Set(TraceparentHeader, "00-"+remoteTrace+"-"+remoteSpan+"-00")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	closeExporter(t)
+
+	spans := c.spans(t)
+	if len(spans) != 2 {
+		t.Fatalf("exported %d spans, want 2: %v", len(spans), spans)
+	}
+
+	server, ok := spans["GET /users/{name}"]
+	if !ok {
+		t.Fatalf("no server span named by its route: %v", spans)
+	}
+
+	if server["traceId"] != remoteTrace || server["parentSpanId"] != remoteSpan {
+		t.Errorf("server span didn't continue the remote trace: %v, %v", server["traceId"], server["parentSpanId"])
+	}
+	if server["kind"] != float64(KindServer) {
+		t.Errorf("server span kind = %v", server["kind"])
+	}
+	if got := attribute(t, server, "http.status_code"); got != "502" {
+		t.Errorf("http.status_code = %v, want 502", got)
+	}
+	if got := attribute(t, server, "http.route"); got != "/users/{name}" {
+		t.Errorf("http.route = %v", got)
+	}
+	if status := object(t, server, "status"); status["code"] != float64(statusError) {
+		t.Errorf("server span status = %v, want an error", status)
+	}
+
+	if db := spans["db select"]; db["parentSpanId"] != server["spanId"] || db["traceId"] != remoteTrace {
+		t.Errorf("db span isn't a child of the server span: %v", db)
+	}
+
+	sc, ok := ParseTraceparent(propagated[0])
+	if !ok || sc.TraceID.String() != remoteTrace || sc.SpanID.String() != server["spanId"] || !sc.Sampled {
+		t.Errorf("propagated traceparent %q doesn't identify the server span", propagated[0])
+	}
+
+	// the unsampled caller's decision is passed on as is
+	if want := "00-" + remoteTrace + "-" + remoteSpan + "-00"; propagated[1] != want {
+		t.Errorf("propagated traceparent %q, want %q", propagated[1], want)
+	}
+}
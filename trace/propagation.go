@@ -0,0 +1,130 @@
+package trace
+
+import (
+	"context"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"github.com/dabbertorres/web-srv-base/httpx"
+	"github.com/dabbertorres/web-srv-base/logme"
+	"github.com/dabbertorres/web-srv-base/realip"
+)
+
+const (
+	// W3C Trace Context
+	TraceparentHeader = "traceparent"
+
+	traceparentVersion = "00"
+	flagSampled        = 0x01
+)
+
+// ParseTraceparent parses a traceparent header, ie: 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01.
+func ParseTraceparent(h string) (sc SpanContext, ok bool) {
+	parts := strings.Split(strings.TrimSpace(h), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" {
+		return
+	}
+
+	// only version 00 is known, and it has exactly 4 parts - later versions may add more
+	if parts[0] == traceparentVersion && len(parts) != 4 {
+		return
+	}
+
+	if !decodeHex(sc.TraceID[:], parts[1]) || !decodeHex(sc.SpanID[:], parts[2]) {
+		return
+	}
+
+	var flags [1]byte
+	if !decodeHex(flags[:], parts[3]) {
+		return
+	}
+	sc.Sampled = flags[0]&flagSampled != 0
+
+	return sc, sc.IsValid()
+}
+
+// Traceparent formats sc as a traceparent header.
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return traceparentVersion + "-" + sc.TraceID.String() + "-" + sc.SpanID.String() + "-" + flags
+}
+
+// decodeHex decodes lowercase hex s into all of dst
+func decodeHex(dst []byte, s string) bool {
+	if len(s) != hex.EncodedLen(len(dst)) || strings.ToLower(s) != s {
+		return false
+	}
+	_, err := hex.Decode(dst, []byte(s))
+	return err == nil
+}
+
+// Extract returns ctx with the remote span described by header's traceparent, for spans started from it to be its
+// children. ctx is returned as is if there isn't a valid one.
+func Extract(ctx context.Context, header http.Header) context.Context {
+	sc, ok := ParseTraceparent(header.Get(TraceparentHeader))
+	if !ok {
+		return ctx
+	}
+	return context.WithValue(ctx, remoteCtxKey{}, sc)
+}
+
+// Inject sets header's traceparent to the span in ctx, ie: for an outgoing request, so it continues the trace.
+func Inject(ctx context.Context, header http.Header) {
+	if sc, ok := SpanContextFrom(ctx); ok {
+		header.Set(TraceparentHeader, sc.Traceparent())
+	}
+}
+
+// Middleware traces each request, continuing the trace of its traceparent header, if it has one. Loggers from
+// logme.Logger.Ctx add the trace's ID to their records.
+// It must be installed on a router, for the route to be known.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !Enabled() {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		route := "unknown"
+		if current := mux.CurrentRoute(r); current != nil {
+			if tmpl, err := current.GetPathTemplate(); err == nil {
+				route = tmpl
+			}
+		}
+
+		ctx := Extract(r.Context(), r.Header)
+		ctx, span := Start(ctx, r.Method+" "+route, KindServer,
+			"http.method", r.Method,
+			"http.route", route,
+			"http.target", r.URL.Path,
+			"http.user_agent", r.UserAgent(),
+			"net.peer.ip", realip.String(r))
+
+		if span != nil {
+			ctx = logme.NewContext(ctx, "trace", span.Context().TraceID.String())
+		}
+
+		rw := httpx.Wrap(w)
+		next.ServeHTTP(rw, r.WithContext(ctx))
+
+		status := rw.Status()
+		span.SetAttributes("http.status_code", status)
+		if status >= http.StatusInternalServerError {
+			span.SetError(httpError(status))
+		}
+		span.End()
+	})
+}
+
+type httpError int
+
+func (e httpError) Error() string {
+	return strconv.Itoa(int(e)) + " " + http.StatusText(int(e))
+}
@@ -0,0 +1,138 @@
+package trace
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  string
+		ok      bool
+		sampled bool
+	}{
+		{
+			name:    "sampled",
+			header:  "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+			ok:      true,
+			sampled: true,
+		},
+		{
+			name:   "not sampled",
+			header: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00",
+			ok:     true,
+		},
+		{
+			name:    "other flags",
+			header:  "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-09",
+			ok:      true,
+			sampled: true,
+		},
+		{
+			name:    "surrounding space",
+			header:  " 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01 ",
+			ok:      true,
+			sampled: true,
+		},
+		{
+			name:    "future version with more parts",
+			header:  "01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+			ok:      true,
+			sampled: true,
+		},
+		{
+			name:   "version 00 with more parts",
+			header: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		},
+		{
+			name:   "invalid version",
+			header: "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		},
+		{
+			name:   "zero trace ID",
+			header: "00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		},
+		{
+			name:   "zero span ID",
+			header: "00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		},
+		{
+			name:   "uppercase",
+			header: "00-4BF92F3577B34DA6A3CE929D0E0E4736-00F067AA0BA902B7-01",
+		},
+		{
+			name:   "short trace ID",
+			header: "00-4bf92f3577b34da6a3ce929d0e0e47-00f067aa0ba902b7-01",
+		},
+		{
+			name:   "not hex",
+			header: "00-4bf92f3577b34da6a3ce929d0e0e473g-00f067aa0ba902b7-01",
+		},
+		{
+			name:   "too few parts",
+			header: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		},
+		{
+			name: "empty",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sc, ok := ParseTraceparent(tt.header)
+			if ok != tt.ok {
+				t.Fatalf("ParseTraceparent(%q) ok = %v, want %v", tt.header, ok, tt.ok)
+			}
+			if !ok {
+				return
+			}
+
+			if sc.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" || sc.SpanID.String() != "00f067aa0ba902b7" {
+				t.Errorf("IDs = %s, %s", sc.TraceID, sc.SpanID)
+			}
+			if sc.Sampled != tt.sampled {
+				t.Errorf("Sampled = %v, want %v", sc.Sampled, tt.sampled)
+			}
+		})
+	}
+}
+
+func TestTraceparentRoundTrip(t *testing.T) {
+	for _, sampled := range []bool{true, false} {
+		want := SpanContext{
+			TraceID: newTraceID(),
+			SpanID:  newSpanID(),
+			Sampled: sampled,
+		}
+
+		got, ok := ParseTraceparent(want.Traceparent())
+		if !ok || got != want {
+			t.Errorf("ParseTraceparent(%q) = %+v, %v, want %+v", want.Traceparent(), got, ok, want)
+		}
+	}
+}
+
+func TestExtractInject(t *testing.T) {
+	in := http.Header{}
+	in.Set(TraceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+
+	ctx := Extract(context.Background(), in)
+
+	out := http.Header{}
+	Inject(ctx, out)
+	if got := out.Get(TraceparentHeader); got != in.Get(TraceparentHeader) {
+		t.Errorf("injected %q, want %q", got, in.Get(TraceparentHeader))
+	}
+
+	// an invalid header is ignored, and nothing is injected
+	in.Set(TraceparentHeader, "garbage")
+	ctx = Extract(context.Background(), in)
+
+	out = http.Header{}
+	Inject(ctx, out)
+	if got := out.Get(TraceparentHeader); got != "" {
+		t.Errorf("injected %q for an invalid traceparent", got)
+	}
+}
@@ -0,0 +1,190 @@
+package trace
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+type TraceID [16]byte
+
+type SpanID [8]byte
+
+func (id TraceID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+func (id TraceID) IsValid() bool {
+	return id != TraceID{}
+}
+
+func (id SpanID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+func (id SpanID) IsValid() bool {
+	return id != SpanID{}
+}
+
+// SpanContext identifies a span, and is what's propagated to other services.
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+}
+
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+// Kind is the role of a span in a trace, as OTLP numbers them.
+type Kind int
+
+const (
+	KindInternal Kind = 1
+	KindServer   Kind = 2
+	KindClient   Kind = 3
+)
+
+// Span is a timed operation, part of a trace. A nil Span does nothing, so callers needn't check whether tracing is on.
+type Span struct {
+	mutex sync.Mutex
+
+	context SpanContext
+	parent  SpanID
+	name    string
+	kind    Kind
+	start   time.Time
+	end     time.Time
+	attrs   []interface{}
+	err     error
+	ended   bool
+}
+
+type spanCtxKey struct{}
+
+type remoteCtxKey struct{}
+
+// Start starts a span named name, as a child of the span in ctx (or the remote one, see Extract), if there is one, or
+// else as the root of a new trace. attrs are alternating keys and values describing it.
+// It returns a nil Span if tracing isn't on, or the parent wasn't sampled.
+func Start(ctx context.Context, name string, kind Kind, attrs ...interface{}) (context.Context, *Span) {
+	if !Enabled() {
+		return ctx, nil
+	}
+
+	parent, ok := SpanContextFrom(ctx)
+	if ok && !parent.Sampled {
+		return ctx, nil
+	}
+
+	s := &Span{
+		context: SpanContext{
+			TraceID: parent.TraceID,
+			SpanID:  newSpanID(),
+			Sampled: true,
+		},
+		parent: parent.SpanID,
+		name:   name,
+		kind:   kind,
+		start:  time.Now(),
+		attrs:  attrs,
+	}
+	if !ok {
+		s.context.TraceID = newTraceID()
+	}
+
+	return context.WithValue(ctx, spanCtxKey{}, s), s
+}
+
+// Record records a span that has already finished, from start until now, ie: for operations with nothing within them
+// to trace, so there's no need for a context.
+func Record(ctx context.Context, name string, kind Kind, start time.Time, err error, attrs ...interface{}) {
+	_, s := Start(ctx, name, kind, attrs...)
+	if s == nil {
+		return
+	}
+
+	s.start = start
+	s.SetError(err)
+	s.End()
+}
+
+// FromContext returns the span in ctx, or nil if there isn't one.
+func FromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanCtxKey{}).(*Span)
+	return s
+}
+
+// SpanContextFrom returns the context of the span in ctx, or of the remote span, if there's only one of those.
+func SpanContextFrom(ctx context.Context) (sc SpanContext, ok bool) {
+	if s := FromContext(ctx); s != nil {
+		return s.context, true
+	}
+
+	sc, ok = ctx.Value(remoteCtxKey{}).(SpanContext)
+	return
+}
+
+func (s *Span) Context() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.context
+}
+
+// SetAttributes adds alternating keys and values describing s.
+func (s *Span) SetAttributes(kv ...interface{}) {
+	if s == nil {
+		return
+	}
+
+	s.mutex.Lock()
+	s.attrs = append(s.attrs, kv...)
+	s.mutex.Unlock()
+}
+
+// SetError marks s as failed, because of err, if it's not nil.
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+
+	s.mutex.Lock()
+	s.err = err
+	s.mutex.Unlock()
+}
+
+// End finishes s, and queues it to be exported. Only the first call does anything.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+
+	s.mutex.Lock()
+	if s.ended {
+		s.mutex.Unlock()
+		return
+	}
+	s.ended = true
+	s.end = time.Now()
+	s.mutex.Unlock()
+
+	enqueue(s)
+}
+
+func newTraceID() (id TraceID) {
+	for !id.IsValid() {
+		rand.Read(id[:])
+	}
+	return
+}
+
+func newSpanID() (id SpanID) {
+	for !id.IsValid() {
+		rand.Read(id[:])
+	}
+	return
+}
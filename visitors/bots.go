@@ -0,0 +1,79 @@
+package visitors
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/dabbertorres/web-srv-base/db"
+)
+
+var (
+	// lowercase user agent fragments of automated browsers
+	headlessAgents = []string{
+		"headlesschrome",
+		"phantomjs",
+		"puppeteer",
+		"playwright",
+		"selenium",
+		"slimerjs",
+	}
+
+	// lowercase user agent fragments of crawlers, link previewers, and HTTP libraries
+	botAgents = []string{
+		"bot",
+		"crawl",
+		"spider",
+		"slurp",
+		"archiver",
+		"facebookexternalhit",
+		"feedfetcher",
+		"mediapartners-google",
+		"bingpreview",
+		"curl/",
+		"wget/",
+		"httpie/",
+		"python-",
+		"go-http-client",
+		"java/",
+		"libwww",
+		"httpclient",
+		"okhttp",
+		"axios/",
+		"node-fetch",
+		"scrapy",
+	}
+)
+
+// classify guesses whether a person, or a program, made r. It returns one of the db.Class constants.
+func classify(r *http.Request) string {
+	agent := strings.ToLower(r.UserAgent())
+
+	for _, s := range headlessAgents {
+		if strings.Contains(agent, s) {
+			return db.ClassHeadless
+		}
+	}
+
+	// client hints aren't spoofed along with the user agent by most automation
+	if strings.Contains(strings.ToLower(r.Header.Get("Sec-CH-UA")), "headless") {
+		return db.ClassHeadless
+	}
+
+	// only crawlers care about robots.txt
+	if agent == "" || r.URL.Path == "/robots.txt" {
+		return db.ClassBot
+	}
+
+	for _, s := range botAgents {
+		if strings.Contains(agent, s) {
+			return db.ClassBot
+		}
+	}
+
+	// browsers always send these - something claiming to be one that doesn't is likely driven by a script
+	if strings.HasPrefix(agent, "mozilla/") && (r.Header.Get("Accept") == "" || r.Header.Get("Accept-Language") == "") {
+		return db.ClassHeadless
+	}
+
+	return db.ClassHuman
+}
@@ -0,0 +1,39 @@
+package visitors
+
+import (
+	"net/url"
+	"unicode/utf8"
+
+	"github.com/dabbertorres/web-srv-base/db"
+)
+
+// the size of the visits.utm* columns
+const maxUTMLength = 255
+
+// campaign pulls the utm_* parameters out of query.
+func campaign(query url.Values) db.Campaign {
+	return db.Campaign{
+		Source:  utmValue(query, "utm_source"),
+		Medium:  utmValue(query, "utm_medium"),
+		Name:    utmValue(query, "utm_campaign"),
+		Term:    utmValue(query, "utm_term"),
+		Content: utmValue(query, "utm_content"),
+	}
+}
+
+func utmValue(query url.Values, key string) string {
+	return truncate(query.Get(key), maxUTMLength)
+}
+
+// truncate shortens v to at most max bytes, without splitting a character.
+func truncate(v string, max int) string {
+	if len(v) <= max {
+		return v
+	}
+
+	v = v[:max]
+	for len(v) > 0 && !utf8.ValidString(v) {
+		v = v[:len(v)-1]
+	}
+	return v
+}
@@ -0,0 +1,83 @@
+package visitors
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/dabbertorres/web-srv-base/db"
+)
+
+// Subscription receives visits as they happen (whether or not they've been recorded yet).
+type Subscription struct {
+	// C is closed when the subscription is, by Unsubscribe or CloseSubscriptions
+	C <-chan db.Visit
+
+	c       chan db.Visit
+	filter  db.VisitFilter
+	dropped uint64
+}
+
+var (
+	subsMutex sync.RWMutex
+	subs      = make(map[*Subscription]struct{})
+)
+
+// Subscribe starts receiving visits matching filter (see db.VisitFilter.Match). Up to buffer visits are held for the
+// subscriber - if it's too slow to keep up, visits are dropped for it, rather than holding up requests.
+func Subscribe(filter db.VisitFilter, buffer int) *Subscription {
+	c := make(chan db.Visit, buffer)
+	sub := &Subscription{
+		C:      c,
+		c:      c,
+		filter: filter,
+	}
+
+	subsMutex.Lock()
+	subs[sub] = struct{}{}
+	subsMutex.Unlock()
+	return sub
+}
+
+// Unsubscribe stops sub from receiving visits. It may be called more than once.
+func (sub *Subscription) Unsubscribe() {
+	subsMutex.Lock()
+	defer subsMutex.Unlock()
+
+	if _, ok := subs[sub]; ok {
+		delete(subs, sub)
+		close(sub.c)
+	}
+}
+
+// Dropped returns how many visits have been dropped because sub's buffer was full.
+func (sub *Subscription) Dropped() uint64 {
+	return atomic.LoadUint64(&sub.dropped)
+}
+
+// CloseSubscriptions ends every subscription, ie: so their requests finish, and the server can shutdown.
+func CloseSubscriptions() {
+	subsMutex.Lock()
+	defer subsMutex.Unlock()
+
+	for sub := range subs {
+		delete(subs, sub)
+		close(sub.c)
+	}
+}
+
+func publish(visit *db.Visit) {
+	subsMutex.RLock()
+	defer subsMutex.RUnlock()
+
+	for sub := range subs {
+		if !sub.filter.Match(visit) {
+			continue
+		}
+
+		select {
+		case sub.c <- *visit:
+		default:
+			atomic.AddUint64(&sub.dropped, 1)
+		}
+	}
+}
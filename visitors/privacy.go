@@ -0,0 +1,89 @@
+package visitors
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"net"
+	"net/http"
+)
+
+// IP address storage modes
+const (
+	IPFull     = "full"
+	IPTruncate = "truncate"
+	IPHash     = "hash"
+)
+
+var (
+	ErrUnknownIPMode = errors.New("unknown visit IP mode")
+	ErrNoIPHashKey   = errors.New("visit IP hashing requires a key")
+)
+
+const (
+	// bits of an address kept when truncating
+	truncateBitsV4 = 24
+	truncateBitsV6 = 48
+
+	// the size of the visits.ip column, so hashes look like, and are stored like, IPv6 addresses
+	hashBytes = net.IPv6len
+)
+
+var (
+	ipMode    = IPFull
+	ipHashKey []byte
+
+	honorDoNotTrack = false
+)
+
+// SetPrivacy configures how much of a visitor is stored.
+// mode is one of IPFull, IPTruncate (keep only the network, ie: a /24 or /48), or IPHash (keyed with key, so addresses
+// can still be told apart, but not recovered without it).
+// If honorDNT is set, requests with "DNT: 1" or "Sec-GPC: 1" headers aren't recorded at all.
+func SetPrivacy(mode string, key []byte, honorDNT bool) error {
+	switch mode {
+	case IPFull, IPTruncate:
+
+	case IPHash:
+		if len(key) == 0 {
+			return ErrNoIPHashKey
+		}
+
+	default:
+		return ErrUnknownIPMode
+	}
+
+	ipMode = mode
+	ipHashKey = key
+	honorDoNotTrack = honorDNT
+	return nil
+}
+
+func doNotTrack(r *http.Request) bool {
+	return honorDoNotTrack && (r.Header.Get("DNT") == "1" || r.Header.Get("Sec-GPC") == "1")
+}
+
+// anonymizeIP applies the IP mode to ip
+func anonymizeIP(ip net.IP) net.IP {
+	if ip == nil {
+		return nil
+	}
+
+	switch ipMode {
+	case IPFull:
+		return ip
+
+	case IPTruncate:
+		if v4 := ip.To4(); v4 != nil {
+			return v4.Mask(net.CIDRMask(truncateBitsV4, 8*net.IPv4len))
+		}
+		return ip.Mask(net.CIDRMask(truncateBitsV6, 8*net.IPv6len))
+
+	case IPHash:
+		mac := hmac.New(sha256.New, ipHashKey)
+		mac.Write(ip.To16())
+		return net.IP(mac.Sum(nil)[:hashBytes])
+	}
+
+	return nil
+}
@@ -0,0 +1,160 @@
+package visitors
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/dabbertorres/web-srv-base/db"
+	"github.com/dabbertorres/web-srv-base/logme"
+)
+
+var (
+	ErrAlreadyStarted = errors.New("visit recording already started")
+	ErrNotStarted     = errors.New("visit recording not started")
+	ErrInvalidBatch   = errors.New("visit queue size, batch size, and flush interval must be positive")
+)
+
+const (
+	flushTimeout = 10 * time.Second
+)
+
+// Stats describes how visit recording is keeping up.
+type Stats struct {
+	Queued   int    `json:"queued"`
+	Recorded uint64 `json:"recorded"`
+	Dropped  uint64 `json:"dropped"`
+	Failed   uint64 `json:"failed"`
+}
+
+var (
+	queue chan db.Visit
+	stop  chan struct{}
+	done  chan struct{}
+
+	recorded uint64
+	dropped  uint64
+	failed   uint64
+
+	// drops since the last time they were logged
+	droppedUnlogged uint64
+
+	logger = logme.For("visitors")
+)
+
+// Start begins recording queued visits to the db in the background.
+// Up to queueSize visits are held in memory; when full, new visits are dropped.
+// They're written in batches of up to batchSize, at least every interval.
+func Start(queueSize, batchSize int, interval time.Duration) error {
+	if queue != nil {
+		return ErrAlreadyStarted
+	}
+
+	if queueSize <= 0 || batchSize <= 0 || interval <= 0 {
+		return ErrInvalidBatch
+	}
+
+	queue = make(chan db.Visit, queueSize)
+	stop = make(chan struct{})
+	done = make(chan struct{})
+
+	go worker(batchSize, interval)
+	return nil
+}
+
+// Stop flushes any queued visits, and stops the background worker.
+func Stop(ctx context.Context) error {
+	if queue == nil {
+		return ErrNotStarted
+	}
+
+	close(stop)
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func GetStats() Stats {
+	return Stats{
+		Queued:   len(queue),
+		Recorded: atomic.LoadUint64(&recorded),
+		Dropped:  atomic.LoadUint64(&dropped),
+		Failed:   atomic.LoadUint64(&failed),
+	}
+}
+
+// enqueue never blocks a request; if the worker can't keep up, the visit is dropped.
+func enqueue(visit *db.Visit) {
+	select {
+	case queue <- *visit:
+	default:
+		atomic.AddUint64(&dropped, 1)
+		atomic.AddUint64(&droppedUnlogged, 1)
+	}
+}
+
+func worker(batchSize int, interval time.Duration) {
+	defer close(done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	batch := make([]db.Visit, 0, batchSize)
+
+	for {
+		select {
+		case v := <-queue:
+			batch = append(batch, v)
+			if len(batch) >= batchSize {
+				batch = flush(batch)
+			}
+
+		case <-ticker.C:
+			batch = flush(batch)
+
+		case <-stop:
+			for {
+				select {
+				case v := <-queue:
+					batch = append(batch, v)
+					if len(batch) >= batchSize {
+						batch = flush(batch)
+					}
+
+				default:
+					flush(batch)
+					return
+				}
+			}
+		}
+	}
+}
+
+// flush writes batch to the db, returning it emptied for reuse
+func flush(batch []db.Visit) []db.Visit {
+	if n := atomic.SwapUint64(&droppedUnlogged, 0); n != 0 {
+		logger.Warn("dropped visits, queue was full", "count", n)
+	}
+
+	if len(batch) == 0 {
+		return batch
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), flushTimeout)
+	err := db.VisitsAdd(ctx, batch)
+	cancel()
+
+	if err != nil {
+		atomic.AddUint64(&failed, uint64(len(batch)))
+		logger.Warn("writing visits to db", "count", len(batch), "err", err)
+	} else {
+		atomic.AddUint64(&recorded, uint64(len(batch)))
+	}
+
+	return batch[:0]
+}
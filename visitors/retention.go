@@ -0,0 +1,111 @@
+package visitors
+
+import (
+	"context"
+	"time"
+
+	"github.com/dabbertorres/web-srv-base/db"
+)
+
+const (
+	retentionInterval = 24 * time.Hour
+	retentionTimeout  = time.Hour
+
+	// keep each delete short, so it doesn't hold locks that block recording new visits
+	retentionDeleteBatch = 10000
+)
+
+// StartRetention removes visits older than maxAge, daily, until Stop is called. Start must be called first.
+// If rollup is set, removed visits are first summarized per day and path in visit_rollups.
+func StartRetention(maxAge time.Duration, rollup bool) error {
+	if stop == nil {
+		return ErrNotStarted
+	}
+
+	go func(stop <-chan struct{}) {
+		ticker := time.NewTicker(retentionInterval)
+		defer ticker.Stop()
+
+		for {
+			applyRetention(stop, maxAge, rollup)
+
+			select {
+			case <-ticker.C:
+			case <-stop:
+				return
+			}
+		}
+	}(stop)
+
+	return nil
+}
+
+func applyRetention(stop <-chan struct{}, maxAge time.Duration, rollup bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), retentionTimeout)
+	defer cancel()
+
+	// don't hold up shutting down
+	go func() {
+		select {
+		case <-stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	// only ever remove whole days, so rollups are complete
+	cutoff := time.Now().UTC().Add(-maxAge).Truncate(24 * time.Hour)
+
+	var (
+		removed int64
+		err     error
+	)
+	if rollup {
+		removed, err = rollupBefore(ctx, cutoff)
+	} else {
+		removed, err = deleteBefore(ctx, cutoff)
+	}
+
+	if err != nil {
+		logger.Error("applying visit retention", "err", err)
+	}
+	if removed != 0 {
+		logger.Info("visit retention removed visits", "before", cutoff, "visits", removed)
+	}
+}
+
+// rollupBefore rolls up each day before cutoff, then deletes its visits in batches. A day left part deleted by an
+// interruption is finished by the next run, without being rolled up again.
+func rollupBefore(ctx context.Context, cutoff time.Time) (total int64, err error) {
+	oldest, err := db.VisitsOldest(ctx)
+	if err != nil || oldest.IsZero() {
+		return
+	}
+
+	for day := oldest.UTC().Truncate(24 * time.Hour); day.Before(cutoff); day = day.AddDate(0, 0, 1) {
+		err = db.VisitsRollupDay(ctx, day)
+		if err != nil {
+			return
+		}
+
+		var deleted int64
+		deleted, err = deleteBefore(ctx, day.AddDate(0, 0, 1))
+		total += deleted
+		if err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+func deleteBefore(ctx context.Context, cutoff time.Time) (total int64, err error) {
+	for {
+		var deleted int64
+		deleted, err = db.VisitsDeleteBefore(ctx, cutoff, retentionDeleteBatch)
+		total += deleted
+		if err != nil || deleted < retentionDeleteBatch {
+			return
+		}
+	}
+}
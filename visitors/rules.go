@@ -0,0 +1,234 @@
+package visitors
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Rule decides whether matching requests are recorded as visits.
+// A request matches when it matches every matcher the rule has. Rules without matchers match everything.
+type Rule struct {
+	Include bool
+
+	// matchers
+	PathPrefixes []string
+	Methods      []string
+	UserAgent    *regexp.Regexp
+	Statuses     []StatusRange
+
+	// the fraction, 0 to 1, of matching requests to record, if Include is set
+	Sample float64
+}
+
+// StatusRange is an inclusive range of status codes.
+type StatusRange struct {
+	Min, Max int
+}
+
+var (
+	rulesMutex sync.RWMutex
+	rules      []Rule
+)
+
+// LoadRules replaces the visit recording rules with those parsed from the file at path.
+func LoadRules(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	parsed, err := ParseRules(file)
+	if err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+
+	SetRules(parsed)
+	return nil
+}
+
+// SetRules replaces the visit recording rules. Requests are checked against each in order, and the first that matches
+// decides whether it is recorded. Requests that match no rules are recorded.
+func SetRules(r []Rule) {
+	rulesMutex.Lock()
+	rules = r
+	rulesMutex.Unlock()
+}
+
+// ParseRules parses one rule per line, formatted as:
+//
+//	<include|exclude> [path=<prefix>[,<prefix>...]] [method=<method>[,<method>...]] [ua=<regexp>] [status=<code|Nxx|min-max>[,...]] [sample=<0-1>]
+//
+// Blank lines, and lines starting with '#' are ignored. As the user agent regexp ends at the first space, use \s to match spaces.
+func ParseRules(r io.Reader) (parsed []Rule, err error) {
+	scan := bufio.NewScanner(r)
+
+	for lineNum := 1; scan.Scan(); lineNum++ {
+		line := strings.TrimSpace(scan.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		var rule Rule
+		rule, err = parseRule(line)
+		if err != nil {
+			err = fmt.Errorf("line %d: %v", lineNum, err)
+			return
+		}
+		parsed = append(parsed, rule)
+	}
+
+	err = scan.Err()
+	return
+}
+
+func parseRule(line string) (rule Rule, err error) {
+	fields := strings.Fields(line)
+
+	switch fields[0] {
+	case "include":
+		rule.Include = true
+	case "exclude":
+		rule.Include = false
+	default:
+		err = fmt.Errorf("unknown action '%s'", fields[0])
+		return
+	}
+
+	rule.Sample = 1
+
+	for _, field := range fields[1:] {
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 || kv[1] == "" {
+			err = fmt.Errorf("expected <key>=<value>, got '%s'", field)
+			return
+		}
+
+		switch kv[0] {
+		case "path":
+			rule.PathPrefixes = strings.Split(kv[1], ",")
+
+		case "method":
+			rule.Methods = strings.Split(strings.ToUpper(kv[1]), ",")
+
+		case "ua":
+			rule.UserAgent, err = regexp.Compile(kv[1])
+			if err != nil {
+				return
+			}
+
+		case "status":
+			for _, s := range strings.Split(kv[1], ",") {
+				var sr StatusRange
+				sr, err = parseStatusRange(s)
+				if err != nil {
+					return
+				}
+				rule.Statuses = append(rule.Statuses, sr)
+			}
+
+		case "sample":
+			rule.Sample, err = strconv.ParseFloat(kv[1], 64)
+			if err != nil {
+				return
+			}
+			if rule.Sample < 0 || rule.Sample > 1 {
+				err = fmt.Errorf("sample must be between 0 and 1, got %v", rule.Sample)
+				return
+			}
+
+		default:
+			err = fmt.Errorf("unknown key '%s'", kv[0])
+			return
+		}
+	}
+
+	return
+}
+
+// parses one of: "404", "4xx", or "400-499"
+func parseStatusRange(s string) (sr StatusRange, err error) {
+	switch {
+	case len(s) == 3 && strings.HasSuffix(strings.ToLower(s), "xx"):
+		var class int
+		class, err = strconv.Atoi(s[:1])
+		sr = StatusRange{Min: class * 100, Max: class*100 + 99}
+
+	case strings.Contains(s, "-"):
+		bounds := strings.SplitN(s, "-", 2)
+		sr.Min, err = strconv.Atoi(bounds[0])
+		if err == nil {
+			sr.Max, err = strconv.Atoi(bounds[1])
+		}
+
+	default:
+		sr.Min, err = strconv.Atoi(s)
+		sr.Max = sr.Min
+	}
+
+	if err != nil {
+		err = fmt.Errorf("invalid status '%s': %v", s, err)
+	}
+	return
+}
+
+func (rule *Rule) matches(r *http.Request, status int) bool {
+	if len(rule.PathPrefixes) != 0 && !matchAny(rule.PathPrefixes, func(p string) bool { return strings.HasPrefix(r.URL.Path, p) }) {
+		return false
+	}
+
+	if len(rule.Methods) != 0 && !matchAny(rule.Methods, func(m string) bool { return m == r.Method }) {
+		return false
+	}
+
+	if rule.UserAgent != nil && !rule.UserAgent.MatchString(r.UserAgent()) {
+		return false
+	}
+
+	if len(rule.Statuses) != 0 {
+		matched := false
+		for _, sr := range rule.Statuses {
+			if sr.Min <= status && status <= sr.Max {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+
+	return true
+}
+
+// shouldRecord applies the first matching rule to the request
+func shouldRecord(r *http.Request, status int) bool {
+	rulesMutex.RLock()
+	defer rulesMutex.RUnlock()
+
+	for i := range rules {
+		rule := &rules[i]
+		if rule.matches(r, status) {
+			return rule.Include && (rule.Sample >= 1 || rand.Float64() < rule.Sample)
+		}
+	}
+
+	return true
+}
+
+func matchAny(list []string, match func(string) bool) bool {
+	for _, s := range list {
+		if match(s) {
+			return true
+		}
+	}
+	return false
+}
@@ -8,35 +8,64 @@ import (
 
 	"github.com/dabbertorres/web-srv-base/db"
 	"github.com/dabbertorres/web-srv-base/dialogue"
-	"github.com/dabbertorres/web-srv-base/logme"
+	"github.com/dabbertorres/web-srv-base/geoip"
+	"github.com/dabbertorres/web-srv-base/httpx"
+	"github.com/dabbertorres/web-srv-base/realip"
 )
 
+// the size of the visits.path, userAgent, and referrer columns - longer values fail the whole batch they're inserted with
+const maxColumnLength = 255
+
 func Middleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if doNotTrack(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
 		_, user := dialogue.IsLoggedIn(r)
+		_, impersonator := dialogue.Impersonator(r)
 
 		queryParams := r.URL.Query()
 		params := bytes.NewBuffer(nil)
 		err := json.NewEncoder(params).Encode(queryParams)
 		if err != nil {
-			logme.Warn().Println("json encoding params:", err)
+			logger.Ctx(r.Context()).Warn("json encoding params", "err", err)
 		}
 
+		// Time drops the monotonic reading with UTC(), so keep start around to measure the duration with
+		start := time.Now()
+
+		ip := realip.IP(r)
+		country, region := geoip.Lookup(ip)
+
 		visit := &db.Visit{
 			User:      user,
-			Time:      time.Now().UTC(),
-			IP:        r.RemoteAddr,
-			UserAgent: r.UserAgent(),
-			Path:      r.RequestURI,
+			Time:      start.UTC(),
+			IP:        anonymizeIP(ip),
+			UserAgent: truncate(r.UserAgent(), maxColumnLength),
+			Path:      truncate(r.URL.Path, maxColumnLength),
 			Method:    r.Method,
 			Params:    params.String(),
-		}
+			Referrer:  truncate(r.Referer(), maxColumnLength),
+			Class:     classify(r),
+			Country:   country,
+			Region:    region,
+			Campaign:  campaign(queryParams),
 
-		err = db.VisitAdd(r.Context(), visit)
-		if err != nil {
-			logme.Warn().Println("writing visit to db:", err)
+			Impersonator: impersonator,
 		}
 
-		next.ServeHTTP(w, r)
+		rw := httpx.Wrap(w)
+		next.ServeHTTP(rw, r)
+
+		visit.Status = rw.Status()
+		visit.Bytes = rw.Bytes()
+		visit.Duration = int64(time.Since(start) / time.Microsecond)
+
+		if shouldRecord(r, visit.Status) {
+			publish(visit)
+			enqueue(visit)
+		}
 	})
 }